@@ -0,0 +1,10 @@
+module github.com/l-lesley-y30/Port-Scan
+
+go 1.23.0
+
+require (
+	golang.org/x/net v0.40.0
+	golang.org/x/time v0.12.0
+)
+
+require golang.org/x/sys v0.33.0 // indirect
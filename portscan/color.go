@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// ANSI escape sequences used for the text output
+const (
+	ansiReset  = "\033[0m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+)
+
+// Decide whether to color output written to f for a -color mode: auto
+// colors only a terminal, and only when NO_COLOR is unset
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		if _, ok := os.LookupEnv("NO_COLOR"); ok {
+			return false, nil
+		}
+		return isTerminal(f), nil
+	}
+	return false, fmt.Errorf("invalid -color %q: must be never, auto or always", mode)
+}
+
+// Wrap s in the color for a port state
+func colorState(state, s string) string {
+	color := ansiYellow // filtered and open|filtered
+	switch state {
+	case scanner.StateOpen:
+		color = ansiGreen
+	case scanner.StateClosed:
+		color = ansiRed
+	}
+	return color + s + ansiReset
+}
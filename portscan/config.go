@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// configGroups are flags that pick the same thing in different ways. When
+// the command line sets any flag of a group, the config file's values for
+// the whole group are ignored, so "-top-ports 100" replaces a profile's
+// "ports: ..." instead of clashing with it.
+var configGroups = [][]string{
+	{"targets", "targets-file", "endpoints-file"},
+	{"ports", "top-ports", "start-port", "end-port", "fast", "endpoints-file"},
+	{"connect-timeout", "timeout"},
+	{"retries", "no-retry"},
+	{"json", "jsonl", "xml", "csv", "grepable", "print-open-ports", "tree", "o", "outdir"},
+	{"4", "6"},
+}
+
+// configEntry is one setting from a config file
+type configEntry struct {
+	line   int
+	name   string
+	values []string // One for a scalar, one per item for a list
+}
+
+// Apply the config file at path: every flag it names takes the file's
+// value unless the command line or the environment already set it (or
+// another flag of its configGroups). Config values then count as given
+// for everything that checks flagSet, as if typed on the command line.
+func loadConfig(path string) error {
+	entries, err := parseConfig(path)
+	if err != nil {
+		return err
+	}
+	explicit := explicitFlags()
+	for _, e := range entries {
+		f := flag.Lookup(e.name)
+		if f == nil || e.name == "config" {
+			return fmt.Errorf("%s:%d: unknown flag %q", path, e.line, e.name)
+		}
+		if explicit[e.name] {
+			continue
+		}
+		if err := setFlag(f, e.values); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, e.line, err)
+		}
+	}
+	return nil
+}
+
+// Apply PORTSCAN_* environment variables, one per flag: the name upper
+// cased with dashes as underscores, so -connect-timeout is
+// PORTSCAN_CONNECT_TIMEOUT. Flags given on the command line (or another
+// flag of their configGroups) win; empty variables are ignored. A
+// repeatable flag takes one value per line.
+func loadEnv() error {
+	explicit := explicitFlags()
+	flags := map[string]*flag.Flag{}
+	flag.VisitAll(func(f *flag.Flag) { flags[envName(f.Name)] = f })
+	for _, kv := range os.Environ() {
+		name, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, envPrefix) || value == "" {
+			continue
+		}
+		f := flags[name]
+		if f == nil {
+			return fmt.Errorf("%s: unknown flag %q", name, strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(name, envPrefix), "_", "-")))
+		}
+		if explicit[f.Name] {
+			continue
+		}
+		if err := setFlag(f, strings.Split(value, "\n")); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+const envPrefix = "PORTSCAN_"
+
+// The environment variable for the flag name
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// Flags already set, on the command line or from the environment, along
+// with the rest of their configGroups
+func explicitFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+		for _, group := range configGroups {
+			if slices.Contains(group, f.Name) {
+				for _, name := range group {
+					explicit[name] = true
+				}
+			}
+		}
+	})
+	return explicit
+}
+
+// Set f from a config or environment value: each item separately for a
+// repeatable flag, joined with commas for any other
+func setFlag(f *flag.Flag, values []string) error {
+	switch f.Value.(type) {
+	case probeFlag, scriptFlag:
+		// Repeatable: each list item is one flag
+	default:
+		values = []string{strings.Join(values, ",")}
+	}
+	for _, v := range values {
+		if err := flag.Set(f.Name, v); err != nil {
+			return fmt.Errorf("invalid %s %q: %v", f.Name, v, err)
+		}
+	}
+	return nil
+}
+
+// Read a config file: a small subset of YAML with one flag per line,
+// named without the dash. Values are scalars, "[a, b]" lists, or lists of
+// "- item" lines under an empty "name:". Quotes around a value are
+// dropped and "#" starts a comment outside them.
+//
+//	targets: [10.0.0.0/24, db1]
+//	ports: 22,80,443
+//	workers: 200
+//	connect-timeout: 2s
+//	probe-script:
+//	  - "25=,45484c4f20780d0a"
+func parseConfig(path string) ([]configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []configEntry
+	var list *configEntry // Entry collecting "- item" lines
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(sc.Text()))
+		if line == "" {
+			continue
+		}
+		if item, ok := strings.CutPrefix(line, "- "); ok || line == "-" {
+			if list == nil {
+				return nil, fmt.Errorf("%s:%d: list item outside a list", path, n)
+			}
+			list.values = append(list.values, unquote(strings.TrimSpace(item)))
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: want name: value", path, n)
+		}
+		e := configEntry{line: n, name: strings.TrimSpace(name)}
+		value = strings.TrimSpace(value)
+		switch {
+		case value == "":
+			// Items follow on the next lines
+		case strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]"):
+			for _, item := range strings.Split(value[1:len(value)-1], ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					e.values = append(e.values, unquote(item))
+				}
+			}
+		default:
+			e.values = []string{unquote(value)}
+		}
+		entries = append(entries, e)
+		list = nil
+		if value == "" {
+			list = &entries[len(entries)-1]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if len(e.values) == 0 {
+			return nil, fmt.Errorf("%s:%d: %s has no value", path, e.line, e.name)
+		}
+	}
+	return entries, nil
+}
+
+// Drop a "#" comment, leaving any "#" inside quotes alone
+func stripComment(line string) string {
+	var quote rune
+	for i, c := range line {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// Remove matching quotes around v
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Run t against a fresh command line parsed from args, sharing the
+// package's flag variables, and put every flag it set back afterwards
+func withFlags(t *testing.T, args ...string) {
+	t.Helper()
+	saved := flag.CommandLine
+	fs := flag.NewFlagSet("portscan", flag.ContinueOnError)
+	saved.VisitAll(func(f *flag.Flag) { fs.Var(f.Value, f.Name, f.Usage) })
+	flag.CommandLine = fs
+	t.Cleanup(func() {
+		fs.Visit(func(f *flag.Flag) { f.Value.Set(f.DefValue) })
+		flag.CommandLine = saved
+	})
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "portscan.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []configEntry
+		wantErr string
+	}{
+		{"scalars", "workers: 200\nconnect-timeout: 2s\n", []configEntry{{1, "workers", []string{"200"}}, {2, "connect-timeout", []string{"2s"}}}, ""},
+		{"inline list", "targets: [10.0.0.0/24, db1]", []configEntry{{1, "targets", []string{"10.0.0.0/24", "db1"}}}, ""},
+		{"item list", "probe-script:\n  - \"25=,45484c4f20780d0a\"\n  - '110=,'\n", []configEntry{{1, "probe-script", []string{"25=,45484c4f20780d0a", "110=,"}}}, ""},
+		{"comments", "# profile\nports: 22,80 # web too\n\ntargets-file: \"scan#1.txt\"\n", []configEntry{{2, "ports", []string{"22,80"}}, {4, "targets-file", []string{"scan#1.txt"}}}, ""},
+		{"item outside a list", "- 22", nil, ":1: list item outside a list"},
+		{"no colon", "workers 200", nil, ":1: want name: value"},
+		{"empty list", "targets:\nworkers: 10", nil, ":1: targets has no value"},
+	}
+	for _, tt := range tests {
+		got, err := parseConfig(writeConfig(t, tt.content))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFlagPrecedence(t *testing.T) {
+	config := "workers: 50\nconnect-timeout: 2s\nports: 22,80\n"
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		workers int
+		timeout time.Duration
+		ports   string
+		top     int
+	}{
+		{"config only", nil, nil, 50, 2 * time.Second, "22,80", 0},
+		{"env over config", nil, map[string]string{"PORTSCAN_WORKERS": "70"}, 70, 2 * time.Second, "22,80", 0},
+		{"command line over env", []string{"-workers", "90"}, map[string]string{"PORTSCAN_WORKERS": "70"}, 90, 2 * time.Second, "22,80", 0},
+		{"alias on command line", []string{"-timeout", "3s"}, nil, 50, 3 * time.Second, "22,80", 0},
+		{"group on command line", []string{"-top-ports", "10"}, nil, 50, 2 * time.Second, "", 10},
+		{"group in env", nil, map[string]string{"PORTSCAN_TOP_PORTS": "5"}, 50, 2 * time.Second, "", 5},
+		{"command line over env group", []string{"-ports", "443"}, map[string]string{"PORTSCAN_TOP_PORTS": "5"}, 50, 2 * time.Second, "443", 0},
+	}
+	path := writeConfig(t, config)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			withFlags(t, tt.args...)
+			if err := loadEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfig(path); err != nil {
+				t.Fatal(err)
+			}
+			if workerCount != tt.workers || timeout != tt.timeout || portList != tt.ports || topPorts != tt.top {
+				t.Errorf("got workers %d, timeout %s, ports %q, top-ports %d, want %d, %s, %q, %d",
+					workerCount, timeout, portList, topPorts, tt.workers, tt.timeout, tt.ports, tt.top)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownFlag(t *testing.T) {
+	withFlags(t)
+	err := loadConfig(writeConfig(t, "workers: 10\nno-such-flag: 1\n"))
+	if err == nil || !strings.Contains(err.Error(), `:2: unknown flag "no-such-flag"`) {
+		t.Errorf("got %v, want an unknown flag error for line 2", err)
+	}
+}
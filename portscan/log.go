@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Diagnostics go here, never to stdout, so they can't mix with results.
+// Replaced by setupLogger once the flags are parsed.
+var logger = slog.New(slog.NewTextHandler(logSink, nil))
+
+// Build the stderr logger from -v, -q, -log-level and -log-format. The
+// default level is warn, so only results and the summary show up.
+func setupLogger() error {
+	level := slog.LevelWarn
+	switch {
+	case logLevel != "":
+		if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+			return fmt.Errorf("invalid -log-level %q: must be debug, info, warn or error", logLevel)
+		}
+	case verbose:
+		level = slog.LevelDebug
+	case quiet:
+		level = slog.LevelError
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch logFormat {
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(logSink, opts))
+	case "text":
+		// Timestamps are noise on an interactive terminal
+		opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		}
+		logger = slog.New(slog.NewTextHandler(logSink, opts))
+	default:
+		return fmt.Errorf("invalid -log-format %q: must be text or json", logFormat)
+	}
+	return nil
+}
+
+// Log err and exit with exitError
+func fatal(err error) {
+	logger.Error(err.Error())
+	os.Exit(exitError)
+}
@@ -1,152 +1,987 @@
 package main
 
 import (
-	"bufio"
+	"context"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
 )
 
-// ScanResult holds the result of a single port scan
-type ScanResult struct {
-	Target string `json:"target"`
-	Port   int    `json:"port"`
-	Banner string `json:"banner,omitempty"` // Optional banner if available
-}
+// Release version reported in JSON output, set at build time with
+// -ldflags "-X main.version=v1.2.3"
+var version = "dev"
 
 // Command-line flags
 var (
-	targets     string // Comma-separated list of targets
-	startPort   int    // Start of port range
-	endPort     int    // End of port range
-	workerCount int    // Number of concurrent workers
-	timeout     int    // Timeout in seconds for each connection attempt
-	jsonOutput  bool   // Output format flag
-	portList    string // Optional list of specific ports
+	targets      string         // Comma-separated list of targets
+	targetsFile  string         // File with one target per line
+	startPort    int            // Start of port range
+	endPort      int            // End of port range
+	workerCount  int            // Number of concurrent workers
+	timeout      time.Duration  // Dial timeout for each connection attempt
+	jsonOutput   bool           // Output format flag
+	portList     string         // Optional list of specific ports
+	tcpPorts     string         // Ports for TCP only, replacing the shared selection
+	udpPorts     string         // Ports for UDP only, replacing the shared selection
+	portsFile    string         // File of ports, one list per line
+	endpoints    string         // File of host:port pairs scanned instead of targets × ports
+	topPorts     int            // Scan the N most common ports instead of a range
+	fast         bool           // Quick preset: the most common ports with short timeouts
+	proto        string         // Protocol(s) to scan
+	onlyOpen     bool           // Hide everything but open ports unless -show-* says otherwise
+	showClosed   bool           // Include closed ports in output
+	showFilter   bool           // Include filtered ports in output
+	includeNetB  bool           // Keep network/broadcast addresses when expanding CIDRs
+	resolveAll   bool           // Scan every address a hostname resolves to
+	collapseIPs  bool           // Merge a hostname's identical results across its addresses
+	allowSpecial bool           // Scan loopback, link-local and multicast addresses inside CIDRs
+	firstOpen    bool           // Stop scanning a host at its first open port
+	bannerHex    bool           // Hex-encode banners that aren't text
+	scanOrder    string         // Order of tasks: host, port or interleave
+	hostConc     int            // Hosts scanned at once with -workers each, 0 for one shared pool
+	dryRun       bool           // Print the scan plan and exit without dialing
+	configPath   string         // File of default flag values
+	maxDuration  time.Duration  // Stop the scan after this long, 0 for no limit
+	watchEvery   time.Duration  // Re-scan this often and print only changes, 0 to scan once
+	ipv4Only     bool           // Scan only IPv4 addresses
+	ipv6Only     bool           // Scan only IPv6 addresses
+	noService    bool           // Skip the port-to-service lookup
+	servicesDB   string         // nmap-style services file overriding the built-in names
+	httpProbeAll bool           // Send an HTTP request to any port that stays silent
+	maxRate      int            // Max connection attempts per second, 0 for unlimited
+	retries      int            // Dial attempts per TCP port
+	retryBackoff time.Duration  // Wait before the first retry, doubled each time
+	retryJitter  float64        // Fraction each backoff is randomized by
+	retryTimeout bool           // Retry only attempts that timed out
+	noRetry      bool           // One attempt per port, for quick sweeps
+	portBudget   time.Duration  // Time limit for all of a port's attempts, 0 for none
+	probeCount   int            // Times to scan each port
+	suspectRatio float64        // Open fraction that marks a host as a likely tarpit
+	suspectMin   int            // Ports a host needs before suspectRatio applies
+	suspectSlow  float64        // Latency growth that marks a host as rate limiting
+	suspectRun   int            // Answers then timeouts that mark a host as gone silent
+	suspectSame  int            // Open ports sharing a banner that mark a host as a likely honeypot
+	progress     bool           // Show a live progress line
+	tuiMode      bool           // Full-screen live view instead of the progress line
+	outputPath   string         // File to write results to instead of stdout
+	grepable     bool           // Output in nmap grepable format
+	xmlOutput    bool           // Output nmap-compatible XML
+	csvOutput    bool           // Output CSV with a header row
+	treeOutput   bool           // Group text output by host, ports nested beneath
+	summaryOnly  bool           // Print only the summary, no result lines
+	openPorts    bool           // Print the open ports as a -ports list
+	openPerHost  bool           // One -print-open-ports line per host
+	expectClosed bool           // Invert the exit code: fail unless every port is open
+	jsonlOutput  bool           // Stream results as newline-delimited JSON
+	randomize    bool           // Shuffle the scan order
+	maxPerHost   int            // Most ports to scan on each host
+	maxResults   int            // Stop the scan after this many open ports
+	discover     bool           // Skip hosts that don't answer a TCP ping
+	discoverICMP bool           // Ping with ICMP echo for discovery
+	synScan      bool           // Half-open SYN scan over raw sockets
+	seed         int64          // Seed for -randomize, 0 picks one
+	colorMode    string         // Colorize text output: never, auto or always
+	excludePorts string         // Ports or ranges never to scan
+	priorityList string         // Ports to scan on every host before the rest
+	excludeHosts string         // IPs or CIDRs never to scan
+	proxyURL     string         // SOCKS5 proxy for TCP connections
+	httpProxyURL string         // HTTP CONNECT proxy for TCP connections
+	resumePath   string         // State file to checkpoint to and resume from
+	sourceIP     string         // Local address to scan from
+	bannerBytes  int            // Read size for banners
+	bannerFull   bool           // Read banners until EOF or the timeout
+	grabBanners  bool           // Read banners from open TCP ports at all
+	bannerWait   time.Duration  // How long to wait for a banner
+	sortBy       string         // Result order: host, port or none
+	maxOpen      int            // Max sockets open at once
+	metricsAddr  string         // Address to serve Prometheus metrics on
+	toSyslog     bool           // Send open ports to syslog as they are found
+	baselinePath string         // File of ports expected to be open
+	hideExpected bool           // Leave out open ports in the baseline
+	syslogAddr   string         // Remote syslog collector, local daemon if empty
+	verbose      bool           // Log per-attempt details
+	showLatency  bool           // Print connect latency in text output
+	quiet        bool           // Log errors only, no progress line
+	noSummary    bool           // Leave out the end-of-scan summary
+	logLevel     string         // Explicit log level, overrides -v and -q
+	logFormat    string         // Diagnostics format: text or json
+	outDir       string         // Directory for one result file per host
+	gzipOutput   bool           // Gzip the -o file or -outdir files
+	skipEmpty    bool           // With -outdir, no file for hosts without open ports
+	timing       int            // Speed template, 0 (slowest) to 5 (fastest)
+	probes       = probeFlag{}  // Custom payloads by port
+	probeScripts = scriptFlag{} // Multi-step probes by port
 )
 
 // Initialize command-line flags
 func init() {
 	flag.StringVar(&targets, "targets", "scanme.nmap.org", "Comma-separated list of IP addresses or hostnames")
+	flag.StringVar(&targetsFile, "targets-file", "", "File of targets (hosts, IPs or CIDRs), one per line; # starts a comment")
 	flag.IntVar(&startPort, "start-port", 1, "Starting port (default 1)")
 	flag.IntVar(&endPort, "end-port", 1024, "Ending port (default 1024)")
 	flag.IntVar(&workerCount, "workers", 100, "Number of concurrent workers")
-	flag.IntVar(&timeout, "timeout", 5, "Connection timeout in seconds")
+	timeout = scanner.DefaultTimeout
+	flag.Var((*durationValue)(&timeout), "connect-timeout", "How long each connection attempt may take, e.g. 300ms or 2s; a bare number is seconds. Banner reads have their own -banner-timeout")
+	flag.Var((*durationValue)(&timeout), "timeout", "Alias for -connect-timeout")
 	flag.BoolVar(&jsonOutput, "json", false, "Output results in JSON format")
-	flag.StringVar(&portList, "ports", "", "Comma-separated list of specific ports to scan (overrides start-end range)")
+	flag.StringVar(&endpoints, "endpoints-file", "", "File of host:port endpoints, one per line, scanned as listed instead of every target × port; # starts a comment")
+	flag.StringVar(&portsFile, "ports-file", "", "File of ports, ranges or service names, one or a comma-separated list per line; # starts a comment. Merged with -ports, -top-ports or an explicit range")
+	flag.StringVar(&tcpPorts, "tcp-ports", "", "Ports to scan over TCP, same syntax as -ports, instead of the shared -ports, -top-ports or range")
+	flag.StringVar(&udpPorts, "udp-ports", "", "Ports to scan over UDP, same syntax as -ports, instead of the shared -ports, -top-ports or range (e.g. -proto both -udp-ports dns,ntp,snmp)")
+	flag.StringVar(&portList, "ports", "", "Comma-separated list of ports, ranges or service names to scan, e.g. ssh,80,8000-8100 (can't be combined with -start-port or -end-port)")
+	flag.StringVar(&priorityList, "priority-ports", "", "Ports to scan on every host before any other, in this order, for early results on the ones that matter (e.g. 22,80,443); same syntax as -ports, and only reorders the scan")
+	flag.StringVar(&excludePorts, "exclude-ports", "", "Ports or ranges to skip, same syntax as -ports; applies to -ports, -top-ports and the range")
+	flag.BoolVar(&fast, "fast", false, fmt.Sprintf("Quick scan of the %d most common ports with a %v timeout, %d workers and %d try per port; -timing or explicit speed flags override the timing", fastPorts, fastTiming.timeout, fastTiming.workers, fastTiming.retries))
+	flag.IntVar(&topPorts, "top-ports", 0, "Scan the N most commonly open ports (UDP list with -proto udp, each protocol's own with -proto both) instead of start-end")
+	flag.StringVar(&proto, "proto", "tcp", "Protocol to scan: tcp, udp or both")
+	flag.BoolVar(&onlyOpen, "only-open", true, "Output only open ports in every format; -only-open=false shows all states (the summary always counts them all)")
+	flag.BoolVar(&showClosed, "show-closed", false, "Include closed ports in the output alongside open ones")
+	flag.BoolVar(&showFilter, "show-filtered", false, "Include filtered (and UDP open|filtered) ports in the output")
+	flag.BoolVar(&noService, "no-service", false, "Don't annotate ports with well-known service names")
+	flag.StringVar(&servicesDB, "services-db", "", "nmap-style services file (name port/proto per line) whose names override the built-in ones, for output and for service names in -ports")
+	flag.IntVar(&retries, "retries", scanner.DefaultRetries, "Connection attempts per TCP port; only timeouts and transient errors are retried")
+	flag.DurationVar(&retryBackoff, "retry-backoff", scanner.DefaultRetryBackoff, "Wait before the first retry, doubled after each attempt")
+	flag.IntVar(&probeCount, "probes", 1, "Scan each port this many times and call it open if any attempt finds it open, showing how many did (e.g. 3/5 probes), for lossy links")
+	flag.DurationVar(&portBudget, "port-budget", 0, "Time limit for all of a TCP port's attempts and backoffs together, so retries can't take several timeouts (0 for none)")
+	flag.BoolVar(&noRetry, "no-retry", false, "Make one connection attempt per port and never wait on a backoff, for quick sweeps where missing a port now and then is fine (same as -retries 1, overriding -timing and -fast)")
+	flag.BoolVar(&retryTimeout, "retry-only-filtered", false, "Retry only connection attempts that timed out; resets, unreachable hosts and other errors are final like refusals")
+	flag.Float64Var(&suspectRatio, "suspect-open-ratio", scanner.DefaultSuspectOpenRatio, "Warn in the summary about hosts with more than this fraction of ports open, a likely tarpit (negative disables)")
+	flag.IntVar(&suspectMin, "suspect-min-ports", scanner.DefaultSuspectMinPorts, "Ports a host must have scanned before -suspect-open-ratio applies")
+	flag.Float64Var(&suspectSlow, "suspect-latency-factor", scanner.DefaultSuspectLatencyFactor, "Warn about hosts where a connect took this many times longer than the host's fastest, a likely rate limit (negative disables)")
+	flag.IntVar(&suspectSame, "suspect-same-banner", scanner.DefaultSuspectSameBanner, "Warn about hosts where this many open ports sent the exact same banner, a likely honeypot or proxy (negative disables)")
+	flag.IntVar(&suspectRun, "suspect-silent-run", scanner.DefaultSuspectSilentRun, "Warn about hosts that answered this many ports and then timed out on at least as many, likely blocking the scan (negative disables)")
+	flag.Float64Var(&retryJitter, "retry-jitter", 0.5, "Randomize each retry backoff by up to this fraction either way so retries spread out, 0 to 1 (0 disables)")
+	flag.StringVar(&sourceIP, "source-ip", "", "Send all probes from this local IP address (must be assigned to an interface)")
+	flag.StringVar(&httpProxyURL, "http-proxy", "", "Send TCP connections through an HTTP proxy with CONNECT, http://[user:pass@]host:port (no UDP)")
+	flag.StringVar(&proxyURL, "proxy", "", "Send TCP connections through a SOCKS5 proxy, socks5://[user:pass@]host:port (no UDP)")
+	flag.IntVar(&maxOpen, "max-open", 0, fmt.Sprintf("Max connections open at once, independent of -workers (default %d, from ulimit -n)", scanner.DefaultMaxOpen()))
+	flag.IntVar(&timing, "timing", 3, "Timing template 0-5 presetting -workers, -rate, -connect-timeout and -retries, from paranoid to insane; explicit flags win")
+	flag.IntVar(&maxRate, "rate", 0, "Max connection attempts per second across all workers (0 = unlimited)")
+	flag.StringVar(&outputPath, "o", "", "Write results to a file; format from extension (.json, .jsonl, .csv, .xml for nmap XML, .txt, .gnmap)")
+	flag.StringVar(&sortBy, "sort", "host", "Order results by host (then port), port (then host), or none for arrival order")
+	flag.BoolVar(&gzipOutput, "gzip", false, "Gzip the -o file, or the -outdir files with .gz added to their names; implied by an -o name ending in .gz")
+	flag.StringVar(&outDir, "outdir", "", "Write one result file per host into this directory, in the -json, -jsonl, -grepable, -xml or text format")
+	flag.BoolVar(&skipEmpty, "skip-empty", false, "With -outdir, don't write files for hosts with no open ports")
+	flag.BoolVar(&jsonlOutput, "jsonl", false, "Stream results to stdout as newline-delimited JSON while scanning")
+	flag.BoolVar(&grepable, "grepable", false, "Output results in nmap-style grepable format, one line per host")
+	flag.BoolVar(&expectClosed, "expect-closed", false, "Invert the exit code for health checks: exit 1 if any scanned port is closed or filtered, 0 only if all are open")
+	flag.BoolVar(&treeOutput, "tree", false, "Group text output by host: each host on a line of its own with its ports nested beneath, easier to read for many hosts")
+	flag.BoolVar(&openPorts, "print-open-ports", false, "Print only the open ports found, as a comma-separated list to pass back to -ports for a second, closer scan")
+	flag.BoolVar(&openPerHost, "open-ports-per-host", false, "With -print-open-ports, print one \"host ports\" line per target instead of a single list for all of them")
+	flag.BoolVar(&summaryOnly, "summary-only", false, "Print only the summary with per-state counts, no result lines; with -json the summary is the whole output")
+	flag.BoolVar(&csvOutput, "csv", false, "Output results as CSV with a header row, for spreadsheets")
+	flag.BoolVar(&xmlOutput, "xml", false, "Output results as nmap-compatible XML (like nmap -oX) for tools that import nmap scans")
+	flag.StringVar(&baselinePath, "baseline", "", "JSON file of expected open ports, as [\"host:port\", ...] or an earlier -json output; open ports are marked expected or new, and the summary lists expected ones not found")
+	flag.BoolVar(&hideExpected, "hide-expected", false, "With -baseline, leave out open ports the baseline expects, so only drift is shown")
+	flag.BoolVar(&toSyslog, "syslog", false, "Also send each open port to syslog as it is found, to the local daemon or -syslog-addr")
+	flag.StringVar(&syslogAddr, "syslog-addr", "", "Remote syslog collector for -syslog, as [udp://|tcp://]host[:port], in RFC 5424 format (default the local daemon)")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at http://ADDR/metrics while scanning, e.g. :9090")
+	flag.BoolVar(&tuiMode, "tui", false, "Show a full-screen live view of open ports, progress and rate while scanning (plain output when not a terminal)")
+	flag.BoolVar(&progress, "progress", true, "Show a progress line while scanning (disabled for -json or non-terminal output)")
+	flag.BoolVar(&grabBanners, "banner", true, "Read a banner from open TCP ports; -banner=false closes each connection at once, much faster when only open ports matter")
+	flag.IntVar(&bannerBytes, "banner-bytes", scanner.DefaultBannerBytes, fmt.Sprintf("Bytes to read for a banner (max %d)", scanner.MaxBannerBytes))
+	flag.BoolVar(&bannerFull, "banner-full", false, fmt.Sprintf("Keep reading banners until EOF or -banner-timeout, up to %d bytes", scanner.MaxBannerBytes))
+	bannerWait = scanner.DefaultBannerTimeout
+	flag.Var((*durationValue)(&bannerWait), "banner-timeout", "How long to wait for a banner once connected, independent of -connect-timeout, e.g. 5s for slow SMTP greeters")
+	flag.Var(probes, "probe", "Payload to send before reading, as port=hexbytes (e.g. 11211=76657273696f6e0d0a); repeatable, port may be a range or service name")
+	flag.Var(probeScripts, "probe-script", "Steps to run over one connection on a port, as port=hex,hex,...; each step is sent and its reply read and added to the banner, an empty step only reads (e.g. 25=,45484c4f20780d0a reads the greeting, then sends EHLO x); repeatable")
+	flag.BoolVar(&httpProbeAll, "http-probe", false, "Send an HTTP GET request to ports that stay silent (web ports are always probed)")
+	flag.BoolVar(&discover, "discover", false, "Check each host with a TCP ping on common ports first and only scan hosts that answer")
+	flag.BoolVar(&discoverICMP, "discover-icmp", false, "Run -discover with ICMP echo instead of TCP pings (needs root or CAP_NET_RAW; falls back to TCP ping without)")
+	flag.BoolVar(&synScan, "syn", false, "SYN-scan TCP ports half-open over raw sockets instead of connecting (needs root or CAP_NET_RAW; falls back to connect scan without; IPv4 only, no banners)")
+	flag.BoolVar(&randomize, "randomize", false, "Scan targets and ports in random order")
+	flag.IntVar(&maxResults, "max-results", 0, "Stop the whole scan as soon as this many open ports have been found, reporting just those (0 for no limit)")
+	flag.IntVar(&maxPerHost, "max-ports-per-host", 0, "Scan at most this many ports on each host and drop the rest, listing capped hosts in the summary; with -randomize, a random sample (0 for no cap)")
+	flag.Int64Var(&seed, "seed", 0, "Seed for -randomize to reproduce an ordering (default random)")
+	flag.StringVar(&colorMode, "color", "auto", "Color the text output by port state: never, auto (terminal and no NO_COLOR) or always")
+	flag.StringVar(&excludeHosts, "exclude-hosts", "", "Comma-separated IPs or CIDRs to leave out of the targets")
+	flag.StringVar(&resumePath, "resume", "", "Checkpoint progress to this state file and resume from it if it exists; removed when the scan completes")
+	flag.BoolVar(&showLatency, "latency", false, "Show how long each open port took to connect in text output (always in JSON as latency_ms)")
+	flag.BoolVar(&verbose, "v", false, "Verbose: show why each port is in its state and log every failed attempt and retry to stderr")
+	flag.BoolVar(&quiet, "q", false, "Quiet: log only errors and hide the progress line")
+	flag.BoolVar(&noSummary, "no-summary", false, "Leave out the end-of-scan summary: the summary block in text output and the one-line summary on stderr with -json, -jsonl, -csv, -xml and -grepable")
+	flag.StringVar(&logLevel, "log-level", "", "Log level for stderr diagnostics: debug, info, warn or error (overrides -v/-q)")
+	flag.StringVar(&logFormat, "log-format", "text", "Format of stderr diagnostics: text or json")
+	flag.BoolVar(&includeNetB, "include-network-broadcast", false, "Scan the network and broadcast addresses of IPv4 CIDR targets")
+	flag.BoolVar(&ipv4Only, "4", false, "Scan only IPv4 addresses: hostnames use their A records and IPv6 targets are skipped")
+	flag.BoolVar(&ipv6Only, "6", false, "Scan only IPv6 addresses: hostnames use their AAAA records and IPv4 targets are skipped")
+	flag.IntVar(&hostConc, "host-concurrency", 0, "Scan up to N hosts at once, each with its own pool of -workers, so a slow host can't starve the rest (default one pool shared by all hosts)")
+	flag.StringVar(&scanOrder, "scan-order", "host", "Order to work through tasks: host (all ports of a host before the next), port (all hosts on a port before the next) or interleave (round-robin across hosts)")
+	flag.BoolVar(&bannerHex, "banner-hex", false, "Show banners that aren't printable text as hex, in every format, so binary protocols keep their exact bytes")
+	flag.BoolVar(&firstOpen, "stop-on-first-open", false, "Stop scanning a host as soon as one of its ports is open, for quick liveness sweeps")
+	flag.BoolVar(&allowSpecial, "allow-special", false, "Scan loopback, link-local and multicast addresses inside CIDR targets instead of skipping them")
+	flag.BoolVar(&resolveAll, "resolve-all", false, "Scan every address a target hostname resolves to, not just the first")
+	flag.BoolVar(&collapseIPs, "collapse-ips", false, "Merge a hostname's results that differ only by address (same port, service and banner) into one listing every address; leave it off for per-address detail")
+	flag.DurationVar(&watchEvery, "watch", 0, "Re-scan every interval, e.g. 60s, printing the first run and then only ports that opened or closed (JSON lines with -json); runs until interrupted")
+	flag.DurationVar(&maxDuration, "max-duration", 0, "Stop scanning after this much wall-clock time, e.g. 30m, and report what was found (default no limit)")
+	flag.StringVar(&configPath, "config", "", "Read default flag values from this file (name: value per line, YAML style); flags on the command line override it")
+	flag.BoolVar(&dryRun, "dry-run", false, "Print the hosts, ports, task count and effective settings, with sample tasks, then exit without dialing anything")
+}
+
+// durationValue is a flag.Value for durations that also takes a bare
+// number as seconds, so "-timeout 5" keeps working alongside "-timeout 500ms"
+type durationValue time.Duration
+
+func (d *durationValue) String() string { return time.Duration(*d).String() }
+
+func (d *durationValue) Set(v string) error {
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		*d = durationValue(secs * float64(time.Second))
+		return nil
+	}
+	parsed, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("want a duration like 750ms or a number of seconds")
+	}
+	*d = durationValue(parsed)
+	return nil
+}
+
+// probeFlag collects repeated -probe port=hexbytes flags
+type probeFlag map[int][]byte
+
+func (p probeFlag) String() string { return "" }
+
+func (p probeFlag) Set(v string) error {
+	portSpec, payload, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want port=hexbytes")
+	}
+	ports, err := scanner.ParsePorts(portSpec)
+	if err != nil {
+		return err
+	}
+	data, err := decodePayload(payload)
+	if err != nil {
+		return err
+	}
+	for _, port := range ports {
+		p[port] = data
+	}
+	return nil
 }
 
-// Attempt to read a banner from an open connection
-func bannerGrab(conn net.Conn) string {
-	conn.SetReadDeadline(time.Now().Add(2 * time.Second)) // Set read timeout
-	reader := bufio.NewReader(conn)
-	buf := make([]byte, 1024)
-	n, _ := reader.Read(buf)
-	return string(buf[:n])
+// scriptFlag collects repeated -probe-script port=hex,hex,... flags, one
+// payload per step; an empty step only reads
+type scriptFlag map[int][][]byte
+
+func (p scriptFlag) String() string { return "" }
+
+func (p scriptFlag) Set(v string) error {
+	portSpec, script, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want port=hexbytes,hexbytes,...")
+	}
+	ports, err := scanner.ParsePorts(portSpec)
+	if err != nil {
+		return err
+	}
+	steps := [][]byte{}
+	for _, step := range strings.Split(script, ",") {
+		data, err := decodePayload(strings.TrimSpace(step))
+		if err != nil {
+			return err
+		}
+		steps = append(steps, data)
+	}
+	for _, port := range ports {
+		p[port] = steps
+	}
+	return nil
 }
 
-// Worker function that scans ports received from the task channel
-func worker(wg *sync.WaitGroup, tasks chan string, results chan ScanResult, dialer net.Dialer, totalPorts int) {
-	defer wg.Done()
-	for task := range tasks {
-		parts := strings.Split(task, ":")
-		port, _ := strconv.Atoi(parts[1])
-		fmt.Printf("Scanning port %d/%d on %s\n", port, totalPorts, parts[0])
-		for i := 0; i < 3; i++ { // Retry up to 3 times with exponential backoff
-			conn, err := dialer.Dial("tcp", task)
-			if err == nil {
-				banner := bannerGrab(conn)
-				results <- ScanResult{Target: parts[0], Port: port, Banner: banner}
-				conn.Close()
-				break
+// Decode a hex payload, with or without a 0x prefix
+func decodePayload(payload string) ([]byte, error) {
+	data, err := hex.DecodeString(strings.TrimPrefix(payload, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload: %v", err)
+	}
+	return data, nil
+}
+
+// Parse ports from a specific list, the top-ports table for topProto or a
+// range, minus any -exclude-ports
+func parsePorts(topProto string) ([]int, error) {
+	ports, err := selectPorts(topProto)
+	if err != nil || excludePorts == "" {
+		return ports, err
+	}
+	excluded, err := scanner.ParsePorts(excludePorts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -exclude-ports: %v", err)
+	}
+	ports = scanner.ExcludePorts(ports, excluded)
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("no ports left to scan after -exclude-ports")
+	}
+	return ports, nil
+}
+
+// Parse -tcp-ports and -udp-ports, minus any -exclude-ports, into lists
+// that replace the shared ports for their protocol. Each needs its
+// protocol in protos. With both protocols the shared -top-ports are the
+// TCP ones, so UDP without a list of its own gets the top UDP ports.
+func parseProtoPorts(protos []string) (map[string][]int, error) {
+	var byProto map[string][]int
+	for _, pp := range []struct{ proto, list string }{{"tcp", tcpPorts}, {"udp", udpPorts}} {
+		if pp.list == "" {
+			if pp.proto == "udp" && topPorts > 0 && len(protos) > 1 {
+				ports, err := parsePorts("udp")
+				if err != nil {
+					return nil, err
+				}
+				if byProto == nil {
+					byProto = map[string][]int{}
+				}
+				byProto[pp.proto] = ports
+			}
+			continue
+		}
+		if !slices.Contains(protos, pp.proto) {
+			return nil, fmt.Errorf("-%s-ports needs -proto %s or both", pp.proto, pp.proto)
+		}
+		ports, err := scanner.ParsePorts(pp.list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -%s-ports: %v", pp.proto, err)
+		}
+		if excludePorts != "" {
+			excluded, err := scanner.ParsePorts(excludePorts)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -exclude-ports: %v", err)
+			}
+			if ports = scanner.ExcludePorts(ports, excluded); len(ports) == 0 {
+				return nil, fmt.Errorf("no %s ports left to scan after -exclude-ports", pp.proto)
 			}
-			time.Sleep(time.Duration(1<<i) * time.Second) // Exponential backoff
 		}
+		if byProto == nil {
+			byProto = map[string][]int{}
+		}
+		byProto[pp.proto] = ports
 	}
+	return byProto, nil
 }
 
-// Parse ports from either a range or a specific list
-func parsePorts() []int {
-	if portList != "" {
-		ports := []int{}
-		for _, p := range strings.Split(portList, ",") {
-			if val, err := strconv.Atoi(strings.TrimSpace(p)); err == nil {
-				ports = append(ports, val)
+// Pick the ports to scan before exclusions. -ports-file merges with
+// whichever of -top-ports, -ports or the range is given; on its own it
+// replaces the default range.
+func selectPorts(topProto string) ([]int, error) {
+	var filePorts []int
+	if portsFile != "" {
+		var err error
+		if filePorts, err = scanner.ParsePortsFile(portsFile); err != nil {
+			return nil, fmt.Errorf("invalid -ports-file: %v", err)
+		}
+	}
+	if topPorts < 0 {
+		return nil, fmt.Errorf("invalid -top-ports %d: must be greater than zero", topPorts)
+	}
+	rangeSet := flagSet("start-port") || flagSet("end-port")
+	if rangeSet {
+		// Two port selections at once is more likely a mistake than a wish
+		// for one to win, so refuse instead of picking
+		for _, name := range []string{"ports", "top-ports"} {
+			if flagSet(name) {
+				return nil, fmt.Errorf("-%s can't be combined with -start-port or -end-port", name)
 			}
 		}
-		return ports
+	}
+	if topPorts > 0 {
+		if portList != "" {
+			return nil, fmt.Errorf("-top-ports and -ports can't be combined")
+		}
+		return append(scanner.TopPorts(topPorts, topProto), filePorts...), nil
+	}
+	if portList != "" {
+		ports, err := scanner.ParsePorts(portList)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -ports: %v", err)
+		}
+		return append(ports, filePorts...), nil
+	}
+	if filePorts != nil && !rangeSet {
+		return filePorts, nil
 	}
 
 	// Use the range if no specific list is provided
-	ports := make([]int, 0, endPort-startPort+1)
-	for p := startPort; p <= endPort; p++ {
-		ports = append(ports, p)
+	if startPort < 1 || endPort > 65535 || startPort > endPort {
+		return nil, fmt.Errorf("invalid port range -start-port %d -end-port %d: must satisfy 1 <= start <= end <= 65535", startPort, endPort)
 	}
-	return ports
+	return append(scanner.PortRange(startPort, endPort), filePorts...), nil
+}
+
+// Report whether a flag was set explicitly on the command line
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Targets given inline. The default target is dropped when -targets-file
+// supplies the list, so it only merges with an explicit -targets.
+func targetList() []string {
+	if targetsFile != "" && !flagSet("targets") {
+		return nil
+	}
+	return strings.Split(targets, ",")
+}
+
+// Split a comma-separated flag value, treating "" as an empty list
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	return strings.Split(v, ",")
+}
+
+// Report whether a result should be printed given -only-open, the
+// -show-* flags and -hide-expected
+func visible(r scanner.ScanResult) bool {
+	if hideExpected && r.Baseline == scanner.BaselineExpected {
+		return false
+	}
+	if !onlyOpen {
+		return true
+	}
+	switch r.State {
+	case scanner.StateClosed:
+		return showClosed
+	case scanner.StateFiltered, scanner.StateOpenFiltered:
+		return showFilter
+	}
+	return true
+}
+
+// File extension for -outdir files, following the stdout format flags
+func dirFormat() string {
+	switch {
+	case jsonOutput:
+		return ".json"
+	case jsonlOutput:
+		return ".jsonl"
+	case grepable:
+		return ".gnmap"
+	case xmlOutput:
+		return ".xml"
+	case csvOutput:
+		return ".csv"
+	}
+	return ".txt"
+}
+
+// Resolve the -proto flag into the list of protocols to scan
+func parseProtos() ([]string, error) {
+	switch proto {
+	case "tcp", "udp":
+		return []string{proto}, nil
+	case "both":
+		return []string{"tcp", "udp"}, nil
+	}
+	return nil, fmt.Errorf("invalid -proto %q: must be tcp, udp or both", proto)
+}
+
+// Exit codes, so CI jobs can gate on what a scan found
+const (
+	exitOK    = 0 // No open ports; with -expect-closed, every port open
+	exitFound = 1 // Open ports found; with -expect-closed, some port not open
+	exitError = 2 // Bad flags, or the scan couldn't run
+)
+
+// Pick the exit code for a finished scan. By default any open port fails
+// the check; -expect-closed inverts that for "must be open" health checks,
+// where a closed, filtered or unscanned port fails it.
+func exitCode(st scanner.Stats) int {
+	if expectClosed {
+		if st.Open < st.Total { // Includes tasks an interrupted scan never ran
+			return exitFound
+		}
+		return exitOK
+	}
+	if st.Open > 0 {
+		return exitFound
+	}
+	return exitOK
 }
 
 func main() {
 	flag.Parse() // Parse command-line arguments
+	if err := loadEnv(); err != nil {
+		fatal(err)
+	}
+	if configPath != "" {
+		if err := loadConfig(configPath); err != nil {
+			fatal(err)
+		}
+	}
+	if err := setupLogger(); err != nil {
+		fatal(err)
+	}
+	if servicesDB != "" {
+		// Before the ports are parsed, so -ports can name local services
+		n, err := scanner.LoadServices(servicesDB, func(err error) {
+			logger.Warn("skipping malformed services entry", "err", err)
+		})
+		if err != nil {
+			fatal(err)
+		}
+		logger.Debug("loaded services", "path", servicesDB, "entries", n)
+	}
 
-	targetList := strings.Split(targets, ",")
-	ports := parsePorts()
-	totalTasks := len(targetList) * len(ports)
+	// Cancel the scan on Ctrl+C or SIGTERM; a second signal kills us outright
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 
-	var wg sync.WaitGroup
-	taskChan := make(chan string, 1000)             // Queue of scan tasks
-	resultChan := make(chan ScanResult, totalTasks) // Channel for storing successful scans
+	if flagSet("timing") {
+		if err := applyTiming(timing); err != nil {
+			fatal(err)
+		}
+	}
+	if fast {
+		if err := applyFast(); err != nil {
+			fatal(err)
+		}
+	}
+	if noRetry {
+		for _, name := range []string{"retries", "retry-backoff", "retry-jitter", "retry-only-filtered"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-no-retry can't be combined with -%s", name))
+			}
+		}
+		retries = 1 // A single attempt has no backoff to sleep through
+	}
 
-	dialer := net.Dialer{Timeout: time.Duration(timeout) * time.Second}
+	protos, err := parseProtos()
+	if err != nil {
+		fatal(err)
+	}
+	discover = discover || discoverICMP // -discover-icmp picks how -discover pings
 
-	startTime := time.Now() // Start timing the scan
+	var ports []int
+	if endpoints != "" {
+		// Endpoints bring their own hosts and ports
+		for _, name := range []string{"targets", "targets-file", "ports", "top-ports", "fast", "ports-file", "start-port", "end-port", "exclude-ports", "tcp-ports", "udp-ports", "discover", "discover-icmp"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-endpoints-file can't be combined with -%s", name))
+			}
+		}
+	} else if ports, err = parsePorts(proto); err != nil {
+		fatal(err)
+	}
+	portsByProto, err := parseProtoPorts(protos)
+	if err != nil {
+		fatal(err)
+	}
+	var priority []int
+	if priorityList != "" {
+		for _, name := range []string{"randomize", "stop-on-first-open", "max-ports-per-host", "host-concurrency", "endpoints-file"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-priority-ports can't be combined with -%s", name))
+			}
+		}
+		if priority, err = scanner.ParsePorts(priorityList); err != nil {
+			fatal(fmt.Errorf("invalid -priority-ports: %v", err))
+		}
+	}
+
+	if sortBy != "host" && sortBy != "port" && sortBy != "none" {
+		fatal(fmt.Errorf("invalid -sort %q: must be host, port or none", sortBy))
+	}
+	if timeout <= 0 {
+		fatal(fmt.Errorf("invalid -connect-timeout %s: must be greater than zero", timeout))
+	}
+	if probeCount < 1 {
+		fatal(fmt.Errorf("invalid -probes %d: must be at least 1", probeCount))
+	}
+	if retryJitter < 0 || retryJitter > 1 {
+		fatal(fmt.Errorf("invalid -retry-jitter %v: must be between 0 and 1", retryJitter))
+	}
+	if portBudget < 0 {
+		fatal(fmt.Errorf("invalid -port-budget %s: must not be negative", portBudget))
+	}
+	ipVersion := 0
+	switch {
+	case ipv4Only && ipv6Only:
+		fatal(fmt.Errorf("-4 and -6 can't be combined"))
+	case ipv4Only:
+		ipVersion = 4
+	case ipv6Only:
+		ipVersion = 6
+	}
+	if !grabBanners {
+		// Nothing left for these to change; -probe and -banner-hex still apply to UDP
+		for _, name := range []string{"banner-bytes", "banner-full", "banner-timeout", "probe-script", "http-probe"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-banner=false can't be combined with -%s", name))
+			}
+		}
+	}
+	if bannerBytes < 1 || bannerBytes > scanner.MaxBannerBytes {
+		fatal(fmt.Errorf("invalid -banner-bytes %d: must be between 1 and %d", bannerBytes, scanner.MaxBannerBytes))
+	}
 
-	// Start worker goroutines
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go worker(&wg, taskChan, resultChan, dialer, len(ports))
+	color, err := useColor(colorMode, os.Stdout)
+	if err != nil {
+		fatal(err)
 	}
 
-	// Feed tasks into the task channel
-	go func() {
-		for _, target := range targetList {
-			for _, port := range ports {
-				taskChan <- net.JoinHostPort(strings.TrimSpace(target), strconv.Itoa(port))
+	var encode func(io.Writer, []scanner.ScanResult) error
+	if outputPath != "" {
+		if encode, err = encoderFor(outputPath); err != nil {
+			fatal(err)
+		}
+	}
+
+	if outDir != "" {
+		if outputPath != "" {
+			fatal(fmt.Errorf("-o and -outdir can't be combined"))
+		}
+	}
+	if gzipOutput && outputPath == "" && outDir == "" {
+		fatal(fmt.Errorf("-gzip needs -o or -outdir; pipe stdout through gzip instead"))
+	}
+	if summaryOnly && (outputPath != "" || outDir != "" || jsonlOutput || grepable || xmlOutput || csvOutput) {
+		fatal(fmt.Errorf("-summary-only works with text or -json output only"))
+	}
+	if openPerHost && !openPorts {
+		fatal(fmt.Errorf("-open-ports-per-host needs -print-open-ports"))
+	}
+	if openPorts {
+		for _, name := range []string{"o", "outdir", "json", "jsonl", "grepable", "xml", "csv", "summary-only", "watch", "tui"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-print-open-ports can't be combined with -%s", name))
 			}
 		}
-		close(taskChan) // Close task channel after all jobs are sent
-	}()
+	}
+	if treeOutput {
+		for _, name := range []string{"json", "jsonl", "grepable", "xml", "csv", "print-open-ports", "summary-only"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-tree can't be combined with -%s", name))
+			}
+		}
+		if outputPath != "" && outputExt(outputPath) != ".txt" {
+			fatal(fmt.Errorf("-tree works with text output only"))
+		}
+	}
+	if maxResults > 0 && resumePath != "" {
+		fatal(fmt.Errorf("-max-results can't be combined with -resume"))
+	}
+	if summaryOnly && noSummary {
+		fatal(fmt.Errorf("-summary-only can't be combined with -no-summary"))
+	}
+	if collapseIPs {
+		// Formats with a record per address, or written as results arrive
+		for _, name := range []string{"outdir", "jsonl", "grepable", "xml", "watch"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-collapse-ips can't be combined with -%s", name))
+			}
+		}
+		if ext := outputExt(outputPath); outputPath != "" && ext != ".json" && ext != ".csv" && ext != ".txt" {
+			fatal(fmt.Errorf("-collapse-ips works with text, JSON or CSV output only"))
+		}
+	}
+	var baseline *scanner.Baseline
+	if baselinePath != "" {
+		if baseline, err = scanner.LoadBaseline(baselinePath); err != nil {
+			fatal(err)
+		}
+		logger.Debug("loaded baseline", "path", baselinePath, "entries", baseline.Len())
+	} else if hideExpected {
+		fatal(fmt.Errorf("-hide-expected needs -baseline"))
+	}
+	if watchEvery < 0 {
+		fatal(fmt.Errorf("invalid -watch %s: must be greater than zero", watchEvery))
+	}
+	if watchEvery > 0 {
+		for _, name := range []string{"o", "outdir", "resume", "summary-only", "tui", "jsonl", "grepable", "xml", "csv", "max-duration"} {
+			if flagSet(name) {
+				fatal(fmt.Errorf("-watch can't be combined with -%s", name))
+			}
+		}
+	}
+	if outDir != "" && !dryRun {
+		if err := os.MkdirAll(outDir, 0o755); err != nil {
+			fatal(err)
+		}
+	}
 
-	wg.Wait()         // Wait for all workers to finish
-	close(resultChan) // Close result channel after workers are done
-	elapsed := time.Since(startTime)
+	if randomize && seed == 0 {
+		seed = time.Now().UnixNano()
+		logger.Info("randomizing scan order", "seed", seed)
+	}
 
-	// Collect results from the result channel
-	results := []ScanResult{}
-	for r := range resultChan {
-		results = append(results, r)
+	s := &scanner.Scanner{
+		Targets:                 targetList(),
+		TargetsFile:             targetsFile,
+		EndpointsFile:           endpoints,
+		Ports:                   ports,
+		PortsByProto:            portsByProto,
+		Protocols:               protos,
+		Workers:                 workerCount,
+		Timeout:                 timeout,
+		Rate:                    maxRate,
+		MaxOpen:                 maxOpen,
+		Proxy:                   proxyURL,
+		HTTPProxy:               httpProxyURL,
+		SourceIP:                sourceIP,
+		Retries:                 retries,
+		RetryBackoff:            retryBackoff,
+		RetryJitter:             retryJitter,
+		RetryOnlyFiltered:       retryTimeout,
+		PortBudget:              portBudget,
+		ProbeCount:              probeCount,
+		SuspectOpenRatio:        suspectRatio,
+		SuspectMinPorts:         suspectMin,
+		SuspectLatencyFactor:    suspectSlow,
+		SuspectSilentRun:        suspectRun,
+		SuspectSameBanner:       suspectSame,
+		IncludeNetworkBroadcast: includeNetB,
+		ExcludeHosts:            splitList(excludeHosts),
+		ResolveAll:              resolveAll,
+		AllowSpecial:            allowSpecial,
+		StopOnFirstOpen:         firstOpen,
+		ScanOrder:               scanOrder,
+		HostConcurrency:         hostConc,
+		IPVersion:               ipVersion,
+		HTTPProbe:               httpProbeAll,
+		Probes:                  probes,
+		ProbeScripts:            probeScripts,
+		BannerBytes:             bannerBytes,
+		BannerTimeout:           bannerWait,
+		BannerFull:              bannerFull,
+		BannerHex:               bannerHex,
+		NoBanner:                !grabBanners,
+		NoService:               noService,
+		Discover:                discover,
+		DiscoverICMP:            discoverICMP,
+		SYN:                     synScan,
+		Randomize:               randomize,
+		MaxPortsPerHost:         maxPerHost,
+		MaxResults:              maxResults,
+		PriorityPorts:           priority,
+		Seed:                    seed,
+		StateFile:               resumePath,
+		Baseline:                baseline,
+		Filter:                  visible,
+		Logger:                  logger,
 	}
 
-	// Output results
-	if jsonOutput {
-		output, _ := json.MarshalIndent(results, "", "  ")
-		fmt.Println(string(output))
-	} else {
-		for _, r := range results {
-			fmt.Printf("[+] %s:%d OPEN", r.Target, r.Port)
-			if r.Banner != "" {
-				fmt.Printf(" - Banner: %q", r.Banner)
+	if dryRun {
+		plan, err := s.Plan(ctx, dryRunSample)
+		if err != nil {
+			fatal(err)
+		}
+		printPlan(plan, discover, randomize)
+		return
+	}
+
+	// With -jsonl each visible result is written straight away instead of
+	// being held in memory; OnResult runs on a single goroutine and each
+	// Encode is a single Write, so lines never interleave.
+	if summaryOnly {
+		s.Filter = func(scanner.ScanResult) bool { return false } // Only the counts are needed
+	}
+	if jsonlOutput && outputPath == "" && outDir == "" {
+		stream := json.NewEncoder(os.Stdout)
+		s.Filter = func(scanner.ScanResult) bool { return false }
+		s.OnResult = func(r scanner.ScanResult) {
+			if visible(r) {
+				stream.Encode(r)
+			}
+		}
+	}
+
+	if toSyslog {
+		w, err := newSyslogWriter(syslogAddr)
+		if err != nil {
+			fatal(err)
+		}
+		next := s.OnResult
+		s.OnResult = func(r scanner.ScanResult) {
+			w.result(r)
+			if next != nil {
+				next(r)
+			}
+		}
+	} else if syslogAddr != "" {
+		fatal(fmt.Errorf("-syslog-addr needs -syslog"))
+	}
+
+	// Open the output file before scanning so a bad path fails fast, but
+	// only once every flag has been checked so a usage error leaves an
+	// existing file alone
+	var outFile *os.File
+	if outputPath != "" {
+		if outFile, err = os.Create(outputPath); err != nil {
+			fatal(err)
+		}
+	}
+	// Compressed formats that can be written a result at a time go into
+	// the file as they arrive, like -jsonl on stdout, instead of waiting
+	// for the scan to end; -collapse-ips needs them all first
+	var stream *resultStream
+	if outFile != nil && (gzipOutput || gzipped(outputPath)) && streamable(outputPath) && !collapseIPs {
+		stream = newResultStream(outFile, outputPath)
+		s.Filter = func(scanner.ScanResult) bool { return false }
+		next := s.OnResult
+		s.OnResult = func(r scanner.ScanResult) {
+			if visible(r) {
+				stream.write(r)
+			}
+			if next != nil {
+				next(r)
+			}
+		}
+	}
+
+	var metrics *http.Server
+	if metricsAddr != "" {
+		if metrics, err = serveMetrics(metricsAddr, s); err != nil {
+			fatal(err)
+		}
+	}
+	if watchEvery > 0 {
+		code := watch(ctx, s, watchEvery, color)
+		if metrics != nil {
+			stopMetrics(metrics)
+		}
+		os.Exit(code)
+	}
+
+	// Draw the progress line or the TUI only for humans watching a terminal
+	stopProgress := make(chan struct{})
+	progressDone := make(chan struct{})
+	interactive := !jsonOutput && !jsonlOutput && !grepable && !xmlOutput && !csvOutput && !openPorts && isTerminal(os.Stdout)
+	if tuiMode && interactive {
+		view := &tui{s: s, targets: strings.Join(s.Targets, ","), color: color}
+		if s.EndpointsFile != "" {
+			view.targets = s.EndpointsFile
+		} else if s.TargetsFile != "" {
+			view.targets = s.TargetsFile
+		}
+		next := s.OnResult
+		s.OnResult = func(r scanner.ScanResult) {
+			view.add(r)
+			if next != nil {
+				next(r)
 			}
-			fmt.Println()
 		}
-		// Print scan summary
-		fmt.Printf("\nScan Summary:\n")
-		fmt.Printf("  Open Ports: %d\n", len(results))
-		fmt.Printf("  Total Ports Scanned: %d\n", totalTasks)
-		fmt.Printf("  Time Taken: %s\n", elapsed)
+		go view.run(stopProgress, progressDone)
+	} else if progress && !quiet && interactive {
+		go showProgress(s, stopProgress, progressDone)
+	} else {
+		close(progressDone)
+	}
+
+	scanCtx := ctx
+	if maxDuration > 0 {
+		var cancel context.CancelFunc
+		scanCtx, cancel = context.WithTimeout(ctx, maxDuration)
+		defer cancel()
+	}
+	started := time.Now()
+	results, err := s.Scan(scanCtx)
+	if metrics != nil {
+		stopMetrics(metrics)
+	}
+	close(stopProgress)
+	<-progressDone
+	stats := s.Stats()
+	if err != nil && stats.Completed == 0 {
+		fatal(err) // Failed before any port was scanned
+	}
+	scanErr := err // Reported once the partial results are written
+	if results == nil {
+		results = []scanner.ScanResult{}
+	}
+	if collapseIPs {
+		results = scanner.CollapseIPs(results)
+	}
+	sortResults(results, sortBy) // Stable order makes repeated runs diffable
+	scanRun, scanTotal = newScanMeta(started, stats), newScanSummary(started, stats, s)
+
+	// Output results
+	summary := func() {
+		if !noSummary {
+			printSummary(stats, discover)
+		}
+	}
+	summaryLine := func() { // For stderr when stdout is data
+		if !noSummary {
+			writeSummaryLine(os.Stderr, stats)
+		}
+	}
+	if outDir != "" {
+		ext := dirFormat()
+		if gzipOutput {
+			ext += ".gz"
+		}
+		n, err := writeHostFiles(outDir, ext, results, skipEmpty)
+		if err != nil {
+			fatal(fmt.Errorf("writing results: %v", err))
+		}
+		fmt.Printf("\nResults for %d hosts written to %s\n", n, outDir)
+		summary()
+	} else if stream != nil {
+		if err := stream.close(); err != nil {
+			fatal(fmt.Errorf("writing results: %v", err))
+		}
+		fmt.Printf("\nResults written to %s\n", outputPath)
+		summary()
+	} else if outFile != nil {
+		if err := writeResultsFile(outFile, encode, results, gzipOutput || gzipped(outputPath)); err != nil {
+			fatal(fmt.Errorf("writing results: %v", err))
+		}
+		fmt.Printf("\nResults written to %s\n", outputPath)
+		summary()
+	} else if summaryOnly && jsonOutput {
+		writeSummaryJSON(os.Stdout, stats)
+	} else if summaryOnly {
+		printSummary(stats, discover)
+	} else if openPorts {
+		writeOpenPorts(os.Stdout, results, openPerHost)
+		summaryLine()
+	} else if jsonlOutput {
+		// Already streamed as results arrived
+		summaryLine()
+	} else if jsonOutput {
+		writeJSON(os.Stdout, results)
+		summaryLine()
+	} else if grepable {
+		writeGrepable(os.Stdout, results)
+		summaryLine()
+	} else if xmlOutput {
+		writeNmapXML(os.Stdout, results)
+		summaryLine()
+	} else if csvOutput {
+		writeCSV(os.Stdout, results)
+		summaryLine()
+	} else {
+		writeLiveHosts(os.Stdout, s.LiveHosts(), color)
+		writeText(os.Stdout, results, textOptions{color: color, reasons: verbose, latency: showLatency, tree: treeOutput})
+		writeResolveErrors(os.Stdout, s.ResolveErrors(), color)
+		summary()
+	}
+	if scanErr != nil {
+		fatal(scanErr)
 	}
+	os.Exit(exitCode(stats))
 }
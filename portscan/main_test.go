@@ -0,0 +1,40 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+func TestTopPortsPerProto(t *testing.T) {
+	defer func(n int, udp string) { topPorts, udpPorts = n, udp }(topPorts, udpPorts)
+	topPorts = 10
+
+	shared, err := parsePorts("both")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := scanner.TopPorts(10, "tcp"); !slices.Equal(shared, want) {
+		t.Errorf("shared ports = %v, want the top TCP ports %v", shared, want)
+	}
+	byProto, err := parseProtoPorts([]string{"tcp", "udp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := scanner.TopPorts(10, "udp"); !slices.Equal(byProto["udp"], want) {
+		t.Errorf("UDP ports = %v, want the top UDP ports %v", byProto["udp"], want)
+	}
+	if _, ok := byProto["tcp"]; ok {
+		t.Errorf("TCP got its own list %v, want the shared one", byProto["tcp"])
+	}
+
+	if byProto, err = parseProtoPorts([]string{"udp"}); err != nil || byProto != nil {
+		t.Errorf("-proto udp: got %v, %v, want no per-protocol lists", byProto, err)
+	}
+
+	udpPorts = "dns"
+	if byProto, err = parseProtoPorts([]string{"tcp", "udp"}); err != nil || !slices.Equal(byProto["udp"], []int{53}) {
+		t.Errorf("-udp-ports dns: got %v, %v, want [53]", byProto["udp"], err)
+	}
+}
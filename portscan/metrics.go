@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// Start an HTTP server on addr exposing the scan's counters at /metrics in
+// the Prometheus text format. The listener is opened before returning so a
+// bad address fails fast.
+func serveMetrics(addr string, s *scanner.Scanner) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("metrics: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, s.Stats())
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// Render the metrics in Prometheus exposition format
+func writeMetrics(w http.ResponseWriter, st scanner.Stats) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	metric("portscan_tasks", "gauge", "Tasks planned for the scan.")
+	fmt.Fprintf(w, "portscan_tasks %d\n", st.Total)
+	metric("portscan_tasks_completed_total", "counter", "Tasks finished so far.")
+	fmt.Fprintf(w, "portscan_tasks_completed_total %d\n", st.Completed)
+	metric("portscan_ports_total", "counter", "Ports found, by state.")
+	for _, c := range []struct {
+		state string
+		n     int64
+	}{
+		{scanner.StateOpen, st.Open},
+		{scanner.StateClosed, st.Closed},
+		{scanner.StateFiltered, st.Filtered},
+		{scanner.StateOpenFiltered, st.OpenFiltered},
+	} {
+		fmt.Fprintf(w, "portscan_ports_total{state=%q} %d\n", c.state, c.n)
+	}
+	metric("portscan_rate_ports_per_second", "gauge", "Average tasks completed per second since the scan started.")
+	fmt.Fprintf(w, "portscan_rate_ports_per_second %g\n", st.PortsPerSec)
+	metric("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+}
+
+// Stop the metrics server, giving in-flight scrapes a moment to finish
+func stopMetrics(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	srv.Shutdown(ctx)
+}
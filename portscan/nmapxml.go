@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// The subset of nmap's XML output (nmap.dtd) that parsers such as
+// python-libnmap or vulnerability-management importers read. Hosts only
+// appear if the scan has results for them, so every one is "up".
+type nmapRun struct {
+	XMLName          xml.Name     `xml:"nmaprun"`
+	Scanner          string       `xml:"scanner,attr"`
+	Args             string       `xml:"args,attr"`
+	Start            int64        `xml:"start,attr"`
+	StartStr         string       `xml:"startstr,attr"`
+	Version          string       `xml:"version,attr"`
+	XMLOutputVersion string       `xml:"xmloutputversion,attr"`
+	Hosts            []nmapHost   `xml:"host"`
+	RunStats         nmapRunStats `xml:"runstats"`
+}
+
+type nmapHost struct {
+	Status    nmapStatus     `xml:"status"`
+	Address   nmapAddress    `xml:"address"`
+	Hostnames []nmapHostname `xml:"hostnames>hostname"`
+	Ports     []nmapPort     `xml:"ports>port"`
+}
+
+type nmapStatus struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+type nmapAddress struct {
+	Addr     string `xml:"addr,attr"`
+	AddrType string `xml:"addrtype,attr"`
+}
+
+type nmapHostname struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type nmapPort struct {
+	Protocol string       `xml:"protocol,attr"`
+	PortID   int          `xml:"portid,attr"`
+	State    nmapState    `xml:"state"`
+	Service  *nmapService `xml:"service"`
+	Scripts  []nmapScript `xml:"script"`
+}
+
+type nmapState struct {
+	State     string `xml:"state,attr"`
+	Reason    string `xml:"reason,attr"`
+	ReasonTTL int    `xml:"reason_ttl,attr"`
+}
+
+type nmapService struct {
+	Name   string `xml:"name,attr"`
+	Method string `xml:"method,attr"` // "table" for a port lookup, "probed" when recognized from the banner
+	Conf   int    `xml:"conf,attr"`
+}
+
+type nmapScript struct {
+	ID     string `xml:"id,attr"`
+	Output string `xml:"output,attr"`
+}
+
+type nmapRunStats struct {
+	Finished nmapFinished `xml:"finished"`
+	Hosts    nmapHosts    `xml:"hosts"`
+}
+
+type nmapFinished struct {
+	Time    int64  `xml:"time,attr"`
+	TimeStr string `xml:"timestr,attr"`
+	Elapsed string `xml:"elapsed,attr"`
+	Summary string `xml:"summary,attr"`
+	Exit    string `xml:"exit,attr"`
+}
+
+type nmapHosts struct {
+	Up    int `xml:"up,attr"`
+	Down  int `xml:"down,attr"`
+	Total int `xml:"total,attr"`
+}
+
+// Write results as an nmap-compatible XML document (-oX), grouped by host
+func writeNmapXML(w io.Writer, results []scanner.ScanResult) error {
+	end := scanRun.Started.Add(time.Duration(scanRun.Duration * float64(time.Second)))
+	run := nmapRun{
+		Scanner:          "portscan",
+		Args:             strings.Join(append([]string{"portscan"}, scanRun.Args...), " "),
+		Start:            scanRun.Started.Unix(),
+		StartStr:         scanRun.Started.Format(time.ANSIC),
+		Version:          version,
+		XMLOutputVersion: "1.05",
+	}
+	for _, h := range groupByHost(results) {
+		run.Hosts = append(run.Hosts, nmapHostFor(h))
+	}
+	n := len(run.Hosts)
+	run.RunStats = nmapRunStats{
+		Finished: nmapFinished{
+			Time:    end.Unix(),
+			TimeStr: end.Format(time.ANSIC),
+			Elapsed: strconv.FormatFloat(scanRun.Duration, 'f', 2, 64),
+			Summary: fmt.Sprintf("portscan done at %s; %d IP addresses (%d hosts up) scanned in %.2f seconds", end.Format(time.ANSIC), n, n, scanRun.Duration),
+			Exit:    "success",
+		},
+		Hosts: nmapHosts{Up: n, Total: n},
+	}
+
+	if _, err := io.WriteString(w, xml.Header+"<!DOCTYPE nmaprun>\n"); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(run); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+// Build the <host> element for one target's results
+func nmapHostFor(h hostResults) nmapHost {
+	addr := h.Target
+	host := nmapHost{Status: nmapStatus{State: "up", Reason: "user-set"}}
+	if h.IP != "" {
+		addr = h.IP
+		host.Hostnames = []nmapHostname{{Name: h.Target, Type: "user"}}
+	}
+	host.Address = nmapAddress{Addr: addr, AddrType: "ipv4"}
+	if ip := net.ParseIP(addr); ip != nil && ip.To4() == nil {
+		host.Address.AddrType = "ipv6"
+	}
+	for _, r := range h.Results {
+		p := nmapPort{Protocol: r.Proto, PortID: r.Port, State: nmapState{State: r.State, Reason: r.Reason}}
+		switch {
+		case r.DetectedProtocol != "":
+			p.Service = &nmapService{Name: r.DetectedProtocol, Method: "probed", Conf: 10}
+		case r.Service != "":
+			p.Service = &nmapService{Name: r.Service, Method: "table", Conf: 3}
+		}
+		if r.Banner != "" {
+			p.Scripts = append(p.Scripts, nmapScript{ID: "banner", Output: r.Banner})
+		}
+		if r.HTTP != nil && r.HTTP.Title != "" {
+			p.Scripts = append(p.Scripts, nmapScript{ID: "http-title", Output: r.HTTP.Title}) // As nmap's script of that name reports it
+		}
+		host.Ports = append(host.Ports, p)
+	}
+	return host
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+func TestWriteNmapXML(t *testing.T) {
+	defer func(saved scanMeta) { scanRun = saved }(scanRun)
+	scanRun = scanMeta{Args: []string{"-targets", "db1,192.0.2.1"}, Started: time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC), Duration: 1.5}
+	results := []scanner.ScanResult{
+		{Target: "192.0.2.1", Port: 22, Proto: "tcp", State: "open", Reason: "syn-ack", Service: "ssh", Banner: "SSH-2.0-OpenSSH_9.6"},
+		{Target: "db1", IP: "2001:db8::5", Port: 53, Proto: "udp", State: "open|filtered", Reason: "no-response", Service: "domain"},
+		{Target: "db1", IP: "2001:db8::5", Port: 80, Proto: "tcp", State: "open", Reason: "syn-ack", Service: "http", DetectedProtocol: "http", HTTP: &scanner.HTTPInfo{Status: 200, Title: "Hello"}},
+	}
+	var out strings.Builder
+	if err := writeNmapXML(&out, results); err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE nmaprun>
+<nmaprun scanner="portscan" args="portscan -targets db1,192.0.2.1" start="1791968400" startstr="Wed Oct 14 09:00:00 2026" version="dev" xmloutputversion="1.05">
+  <host>
+    <status state="up" reason="user-set" reason_ttl="0"></status>
+    <address addr="192.0.2.1" addrtype="ipv4"></address>
+    <hostnames></hostnames>
+    <ports>
+      <port protocol="tcp" portid="22">
+        <state state="open" reason="syn-ack" reason_ttl="0"></state>
+        <service name="ssh" method="table" conf="3"></service>
+        <script id="banner" output="SSH-2.0-OpenSSH_9.6"></script>
+      </port>
+    </ports>
+  </host>
+  <host>
+    <status state="up" reason="user-set" reason_ttl="0"></status>
+    <address addr="2001:db8::5" addrtype="ipv6"></address>
+    <hostnames>
+      <hostname name="db1" type="user"></hostname>
+    </hostnames>
+    <ports>
+      <port protocol="udp" portid="53">
+        <state state="open|filtered" reason="no-response" reason_ttl="0"></state>
+        <service name="domain" method="table" conf="3"></service>
+      </port>
+      <port protocol="tcp" portid="80">
+        <state state="open" reason="syn-ack" reason_ttl="0"></state>
+        <service name="http" method="probed" conf="10"></service>
+        <script id="http-title" output="Hello"></script>
+      </port>
+    </ports>
+  </host>
+  <runstats>
+    <finished time="1791968401" timestr="Wed Oct 14 09:00:01 2026" elapsed="1.50" summary="portscan done at Wed Oct 14 09:00:01 2026; 2 IP addresses (2 hosts up) scanned in 1.50 seconds" exit="success"></finished>
+    <hosts up="2" down="0" total="2"></hosts>
+  </runstats>
+</nmaprun>
+`
+	if got := out.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,922 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// textOptions tune the human-readable output
+type textOptions struct {
+	color   bool // Color the marker and state; banners are always left plain
+	reasons bool // Say why each port is in its state
+	latency bool // Show how long open ports took to connect
+	tree    bool // Each host once, with its ports nested beneath
+}
+
+// Write results as human-readable lines
+func writeText(w io.Writer, results []scanner.ScanResult, opts textOptions) error {
+	if opts.tree {
+		return writeTree(w, results, opts)
+	}
+	for _, r := range results {
+		if _, err := fmt.Fprintln(w, textLine(r, opts, true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write results grouped by target, in the order targets first appear:
+// a line naming the host, then one branch per port
+//
+//	10.0.0.5
+//	├── 22/ssh OPEN - Banner: "SSH-2.0-OpenSSH_9.6\r\n"
+//	└── 443/https OPEN
+func writeTree(w io.Writer, results []scanner.ScanResult, opts textOptions) error {
+	var targets []string
+	groups := map[string][]scanner.ScanResult{}
+	for _, r := range results {
+		if _, ok := groups[r.Target]; !ok {
+			targets = append(targets, r.Target)
+		}
+		groups[r.Target] = append(groups[r.Target], r)
+	}
+	for _, target := range targets {
+		group := groups[target]
+		host, ip := target, group[0].IP
+		for _, r := range group {
+			if r.IP != ip || len(r.IPs) > 0 {
+				ip = "" // Several addresses; each port line says which
+			}
+		}
+		if ip != "" {
+			host += " (" + ip + ")"
+		}
+		if _, err := fmt.Fprintln(w, host); err != nil {
+			return err
+		}
+		for i, r := range group {
+			branch := "├── "
+			if i == len(group)-1 {
+				branch = "└── "
+			}
+			if ip != "" {
+				r.IP = "" // Already on the host line
+			}
+			if _, err := fmt.Fprintln(w, branch+textLine(r, opts, false)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Render a result as a line of text output: a marker and the host and
+// port, or with host false just the port, followed by the state and
+// whatever else is known
+func textLine(r scanner.ScanResult, opts textOptions, host bool) string {
+	mark := "[+]"
+	if r.State != scanner.StateOpen {
+		mark = "[-]"
+	}
+	port := strconv.Itoa(r.Port)
+	if r.Service != "" {
+		port += "/" + r.Service
+	}
+	state := strings.ToUpper(r.State)
+	if opts.color {
+		mark, state = colorState(r.State, mark), colorState(r.State, state)
+	}
+	line := port + " " + state
+	if host {
+		line = fmt.Sprintf("%s %s %s", mark, net.JoinHostPort(r.Target, port), state) // Brackets IPv6 hosts
+	}
+	if len(r.IPs) > 0 {
+		line += " (" + strings.Join(r.IPs, ", ") + ")"
+	} else if r.IP != "" {
+		line += " (" + r.IP + ")"
+	}
+	if r.Proto == "udp" {
+		line += " (udp)"
+	}
+	if r.Baseline == scanner.BaselineNew {
+		line += " (new)" // Not in the baseline
+	}
+	if opts.reasons && r.Reason != "" {
+		line += " (" + r.Reason + ")"
+	}
+	if opts.latency && r.LatencyMs > 0 {
+		line += fmt.Sprintf(" %.2fms", r.LatencyMs)
+	}
+	if r.Probes > 0 {
+		line += fmt.Sprintf(" (%d/%d probes)", r.Hits, r.Probes)
+	}
+	if r.DetectedProtocol != "" && r.DetectedProtocol != r.Service {
+		line += " [" + r.DetectedProtocol + "]" // Not what the port number suggests
+	}
+	if r.Banner != "" {
+		line += " - " + bannerText(r)
+	}
+	return line
+}
+
+// Render a result's banner for a text line: quoted, or as the hex string
+// with -banner-hex
+func bannerText(r scanner.ScanResult) string {
+	if r.BannerEncoding == scanner.BannerEncodingHex {
+		return "Banner (hex): " + r.Banner
+	}
+	return fmt.Sprintf("Banner: %q", r.Banner)
+}
+
+// Version of the JSON document's layout, bumped whenever it changes in a
+// way parsers have to know about
+const jsonSchemaVersion = 1
+
+// scanMeta describes the scan in the JSON document, so an archived file
+// says what produced it
+type scanMeta struct {
+	Version  string     `json:"version"` // portscan release
+	Args     []string   `json:"args"`    // Command line, without the program name
+	Started  time.Time  `json:"started"`
+	Duration float64    `json:"duration_seconds"`
+	Counts   scanCounts `json:"counts"`
+}
+
+// scanCounts are the task and per-state totals in scanMeta
+type scanCounts struct {
+	Total        int64 `json:"total"`
+	Completed    int64 `json:"completed"`
+	Open         int64 `json:"open"`
+	Closed       int64 `json:"closed"`
+	Filtered     int64 `json:"filtered"`
+	OpenFiltered int64 `json:"open_filtered"`
+}
+
+// scanSummary is the summary section of the JSON document and of
+// -summary-only -json: every counter from Stats plus when the scan ran
+type scanSummary struct {
+	scanner.Stats
+	Started       time.Time      `json:"started"`
+	Finished      time.Time      `json:"finished"`
+	ResolveErrors []resolveError `json:"resolve_errors,omitempty"` // Target hostnames that didn't resolve
+	LiveHosts     []liveHost     `json:"live_hosts,omitempty"`     // Hosts that answered discovery
+	SuspectHosts  []suspectHost  `json:"suspect_hosts,omitempty"`  // Hosts whose results look unreliable
+	BannerCounts  []bannerCount  `json:"banner_counts,omitempty"`  // Banners and distinct banners per host
+	CappedHosts   []cappedHost   `json:"capped_hosts,omitempty"`   // Hosts cut short by -max-ports-per-host
+	HostDials     []hostDials    `json:"host_dials,omitempty"`     // Hosts with dial timeouts or retries
+	Baseline      *baselineDrift `json:"baseline,omitempty"`       // Open ports against -baseline
+}
+
+// How the open ports compare to -baseline, in JSON
+type baselineDrift struct {
+	Expected int      `json:"expected"`
+	New      int      `json:"new"`
+	Missing  []string `json:"missing"` // Baseline entries not found open
+}
+
+// Connection attempts to a host that had timeouts or retries, in JSON
+type hostDials struct {
+	Host     string `json:"host"`
+	Attempts int64  `json:"attempts"`
+	Failures int64  `json:"failures"`
+	Timeouts int64  `json:"timeouts"`
+	Retries  int64  `json:"retries"`
+}
+
+// A host that reached -max-ports-per-host, in JSON
+type cappedHost struct {
+	Target  string `json:"target"`
+	IP      string `json:"ip,omitempty"`
+	Dropped int    `json:"dropped"`
+}
+
+// How many banners a host sent and how many differed, in JSON
+type bannerCount struct {
+	Target   string `json:"target"`
+	IP       string `json:"ip,omitempty"`
+	Banners  int    `json:"banners"`
+	Distinct int    `json:"distinct"`
+}
+
+// A host whose results look like a tarpit or rate limit, in JSON
+type suspectHost struct {
+	Target string `json:"target"`
+	IP     string `json:"ip,omitempty"`
+	Reason string `json:"reason"`
+	Detail string `json:"detail"`
+}
+
+// A host that answered discovery, in JSON
+type liveHost struct {
+	Host  string  `json:"host"`
+	RTTMs float64 `json:"rtt_ms"`
+}
+
+// A target hostname that failed to resolve, in JSON
+type resolveError struct {
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+// Summarize a scan that started at started and ended with st, listing
+// what s found beyond the counts: the targets that didn't resolve, the
+// hosts discovery found up, the hosts whose results look unreliable,
+// were capped or had dial timeouts or retries, the banner counts per host
+// and the drift from the baseline. s may be nil for just the counts.
+func newScanSummary(started time.Time, st scanner.Stats, s *scanner.Scanner) scanSummary {
+	sum := scanSummary{Stats: st, Started: started.UTC(), Finished: started.Add(st.Elapsed).UTC()}
+	if s == nil {
+		return sum
+	}
+	for _, e := range s.ResolveErrors() {
+		sum.ResolveErrors = append(sum.ResolveErrors, resolveError{Target: e.Host, Error: e.Err.Error()})
+	}
+	for _, h := range s.LiveHosts() {
+		sum.LiveHosts = append(sum.LiveHosts, liveHost{Host: h.Host, RTTMs: rttMs(h.RTT)})
+	}
+	for _, h := range s.SuspectHosts() {
+		sum.SuspectHosts = append(sum.SuspectHosts, suspectHost{Target: h.Target, IP: h.IP, Reason: h.Reason, Detail: h.Detail})
+	}
+	for _, c := range s.BannerCounts() {
+		sum.BannerCounts = append(sum.BannerCounts, bannerCount{Target: c.Target, IP: c.IP, Banners: c.Ports, Distinct: c.Distinct})
+	}
+	for _, h := range s.CappedHosts() {
+		sum.CappedHosts = append(sum.CappedHosts, cappedHost{Target: h.Target, IP: h.IP, Dropped: h.Dropped})
+	}
+	for _, h := range s.HostDialStats() {
+		sum.HostDials = append(sum.HostDials, hostDials{Host: h.Host, Attempts: h.Attempts, Failures: h.Failures, Timeouts: h.Timeouts, Retries: h.Retries})
+	}
+	if s.Baseline != nil {
+		sum.Baseline = &baselineDrift{Missing: s.BaselineMissing()}
+		sum.Baseline.Expected, sum.Baseline.New = s.BaselineCounts()
+		if sum.Baseline.Missing == nil {
+			sum.Baseline.Missing = []string{}
+		}
+	}
+	return sum
+}
+
+// Round-trip time in milliseconds, to the microsecond
+func rttMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// List the hosts discovery found up with their round-trip times
+func writeLiveHosts(w io.Writer, live []scanner.LiveHost, color bool) {
+	for _, h := range live {
+		mark, state := "[*]", "UP"
+		if color {
+			mark, state = ansiGreen+mark+ansiReset, ansiGreen+state+ansiReset
+		}
+		fmt.Fprintf(w, "%s %s %s (rtt %.2fms)\n", mark, h.Host, state, rttMs(h.RTT))
+	}
+}
+
+// List the targets that didn't resolve, one line each, so a bad name
+// stands out in the text output instead of just going missing
+func writeResolveErrors(w io.Writer, failed []*scanner.ResolveError, color bool) {
+	for _, e := range failed {
+		mark, state := "[!]", "UNRESOLVED"
+		if color {
+			mark, state = ansiRed+mark+ansiReset, ansiRed+state+ansiReset
+		}
+		fmt.Fprintf(w, "%s %s %s - %v\n", mark, e.Host, state, e.Err)
+	}
+}
+
+// Metadata and summary of the finished scan, set by main before any
+// results are written
+var (
+	scanRun   scanMeta
+	scanTotal scanSummary
+)
+
+// Describe a scan that started at started and ended with st
+func newScanMeta(started time.Time, st scanner.Stats) scanMeta {
+	return scanMeta{
+		Version:  version,
+		Args:     os.Args[1:],
+		Started:  started.UTC(),
+		Duration: st.ElapsedSeconds,
+		Counts: scanCounts{
+			Total:        st.Total,
+			Completed:    st.Completed,
+			Open:         st.Open,
+			Closed:       st.Closed,
+			Filtered:     st.Filtered,
+			OpenFiltered: st.OpenFiltered,
+		},
+	}
+}
+
+// Write results as an indented JSON document, the results wrapped with
+// the schema version and the scan's metadata:
+//
+//	{"schema_version": 1, "scan": {...}, "summary": {...}, "results": [...]}
+//
+// See schema.json for the full layout.
+func writeJSON(w io.Writer, results []scanner.ScanResult) error {
+	output, err := json.MarshalIndent(struct {
+		SchemaVersion int                  `json:"schema_version"`
+		Scan          scanMeta             `json:"scan"`
+		Summary       scanSummary          `json:"summary"`
+		Results       []scanner.ScanResult `json:"results"`
+	}{jsonSchemaVersion, scanRun, scanTotal, results}, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(output))
+	return err
+}
+
+// Write results as newline-delimited JSON, one object per line
+func writeJSONLines(w io.Writer, results []scanner.ScanResult) error {
+	enc := json.NewEncoder(w)
+	for _, r := range results {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Columns of the CSV output: the six core ones first, then the extras.
+// New ones are only ever added at the end, so spreadsheets and scripts
+// that go by position keep working.
+var csvHeader = []string{"target", "port", "state", "service", "banner", "latency_ms", "ip", "proto", "http_server", "banner_encoding", "baseline"}
+
+// Write results as CSV with a header row
+func writeCSV(w io.Writer, results []scanner.ScanResult) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, r := range results {
+		cw.Write(csvRow(r))
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// The CSV record for r, in csvHeader order
+func csvRow(r scanner.ScanResult) []string {
+	latency := ""
+	if r.LatencyMs > 0 {
+		latency = strconv.FormatFloat(r.LatencyMs, 'f', -1, 64)
+	}
+	ip := r.IP
+	if len(r.IPs) > 0 {
+		ip = strings.Join(r.IPs, " ")
+	}
+	return []string{r.Target, strconv.Itoa(r.Port), r.State, r.Service, r.Banner, latency, ip, r.Proto, r.HTTPServer, r.BannerEncoding, r.Baseline}
+}
+
+// Order targets with IPs numerically (IPv4 before IPv6) ahead of hostnames,
+// which sort alphabetically
+func compareTargets(a, b string) int {
+	ipA, errA := netip.ParseAddr(a)
+	ipB, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return ipA.Compare(ipB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+// Sort results in place for -sort: "host" orders by target then port,
+// "port" by port then target, and "none" keeps the arrival order
+func sortResults(results []scanner.ScanResult, by string) {
+	byHost := func(a, b scanner.ScanResult) int {
+		return cmp.Or(compareTargets(a.Target, b.Target), compareTargets(a.IP, b.IP), cmp.Compare(a.Port, b.Port), strings.Compare(a.Proto, b.Proto))
+	}
+	byPort := func(a, b scanner.ScanResult) int {
+		return cmp.Or(cmp.Compare(a.Port, b.Port), compareTargets(a.Target, b.Target), compareTargets(a.IP, b.IP), strings.Compare(a.Proto, b.Proto))
+	}
+	switch by {
+	case "host":
+		slices.SortStableFunc(results, byHost)
+	case "port":
+		slices.SortStableFunc(results, byPort)
+	}
+}
+
+// hostResults groups the results for a single target address
+type hostResults struct {
+	Target  string
+	IP      string // Address scanned when Target is a hostname
+	Results []scanner.ScanResult
+}
+
+// Group results by target and address, keeping hosts in order of first
+// appearance
+func groupByHost(results []scanner.ScanResult) []hostResults {
+	groups := []hostResults{}
+	index := map[[2]string]int{}
+	for _, r := range results {
+		key := [2]string{r.Target, r.IP}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, hostResults{Target: r.Target, IP: r.IP})
+		}
+		groups[i].Results = append(groups[i].Results, r)
+	}
+	return groups
+}
+
+// Write results in nmap's grepable (-oG) format, one line per host:
+//
+//	Host: 10.0.0.1 ()	Ports: 22/open/tcp//ssh///, 80/open/tcp//http///
+//
+// Hostname targets are shown nmap-style as "Host: <ip> (<name>)".
+func writeGrepable(w io.Writer, results []scanner.ScanResult) error {
+	for _, h := range groupByHost(results) {
+		entries := make([]string, 0, len(h.Results))
+		for _, r := range h.Results {
+			// port/state/protocol/owner/service/rpc info/version/
+			entries = append(entries, fmt.Sprintf("%d/%s/%s//%s///", r.Port, r.State, r.Proto, r.Service))
+		}
+		host, name := h.Target, ""
+		if h.IP != "" {
+			host, name = h.IP, h.Target
+		}
+		if _, err := fmt.Fprintf(w, "Host: %s (%s)\tPorts: %s\n", host, name, strings.Join(entries, ", ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the open ports in results as a -ports list for a follow-up scan,
+// ranges folded: either one line for all hosts, or with perHost one line
+// per target as given, each "target ports", in the order of results.
+//
+//	22,80,443,8000-8002
+func writeOpenPorts(w io.Writer, results []scanner.ScanResult, perHost bool) error {
+	var targets []string
+	ports := map[string][]int{}
+	for _, r := range results {
+		if r.State != scanner.StateOpen {
+			continue
+		}
+		target := ""
+		if perHost {
+			target = r.Target
+		}
+		if _, ok := ports[target]; !ok {
+			targets = append(targets, target)
+		}
+		ports[target] = append(ports[target], r.Port)
+	}
+	for _, target := range targets {
+		line := portRanges(ports[target])
+		if perHost {
+			line = target + " " + line
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Format ports as a sorted, deduplicated list with runs folded into
+// ranges, e.g. "22,80-82"
+func portRanges(ports []int) string {
+	ports = slices.Clone(ports)
+	slices.Sort(ports)
+	ports = slices.Compact(ports)
+	var parts []string
+	for i := 0; i < len(ports); {
+		j := i
+		for j+1 < len(ports) && ports[j+1] == ports[j]+1 {
+			j++
+		}
+		part := strconv.Itoa(ports[i])
+		if j > i {
+			part += "-" + strconv.Itoa(ports[j])
+		}
+		parts = append(parts, part)
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Report whether an output path asks for gzip compression
+func gzipped(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".gz")
+}
+
+// The extension naming an output path's format, lowercased and looking
+// past a trailing .gz
+func outputExt(path string) string {
+	if gzipped(path) {
+		path = path[:len(path)-len(".gz")]
+	}
+	return strings.ToLower(filepath.Ext(path))
+}
+
+// Pick the result encoder for an output path based on its extension
+func encoderFor(path string) (func(io.Writer, []scanner.ScanResult) error, error) {
+	switch outputExt(path) {
+	case ".json":
+		return writeJSON, nil
+	case ".jsonl":
+		return writeJSONLines, nil
+	case ".csv":
+		return writeCSV, nil
+	case ".xml":
+		return writeNmapXML, nil
+	case ".txt":
+		return func(w io.Writer, results []scanner.ScanResult) error {
+			return writeText(w, results, textOptions{tree: treeOutput})
+		}, nil
+	case ".gnmap":
+		return writeGrepable, nil
+	}
+	return nil, fmt.Errorf("cannot infer output format from %q: use .json, .jsonl, .csv, .xml, .txt or .gnmap, optionally followed by .gz", path)
+}
+
+// Turn a target into a safe file name; IPv6 colons and zone markers are
+// replaced since some filesystems reject them
+func hostFileName(host string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '%', '/', '\\':
+			return '_'
+		}
+		return r
+	}, host)
+}
+
+// Write each host's results to its own file in dir, named after the host
+// (and the address scanned, for hostnames) with ext choosing the format
+// and a trailing .gz compressing it. Hosts without an open port are
+// skipped if skipEmpty is set. Returns the number of files written.
+func writeHostFiles(dir, ext string, results []scanner.ScanResult, skipEmpty bool) (int, error) {
+	encode, err := encoderFor(ext)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, h := range groupByHost(results) {
+		if skipEmpty && !slices.ContainsFunc(h.Results, func(r scanner.ScanResult) bool {
+			return r.State == scanner.StateOpen
+		}) {
+			continue
+		}
+		name := h.Target
+		if h.IP != "" {
+			name += "_" + h.IP // A name resolving to several addresses gets a file for each
+		}
+		f, err := os.Create(filepath.Join(dir, hostFileName(name)+ext))
+		if err != nil {
+			return n, err
+		}
+		if err := writeResultsFile(f, encode, h.Results, gzipped(ext)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Write results to f with the given encoder, flushing and closing the file.
+// With compress the output is gzipped on its way to f, a block at a time,
+// so no compressed copy is held in memory; results themselves already
+// are. Formats a resultStream can write skip that and stream instead.
+func writeResultsFile(f *os.File, encode func(io.Writer, []scanner.ScanResult) error, results []scanner.ScanResult, compress bool) error {
+	var w io.Writer = f
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(f)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+	err := encode(bw, results)
+	if ferr := bw.Flush(); err == nil {
+		err = ferr
+	}
+	if gz != nil {
+		if gerr := gz.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// resultStream gzips results into an -o file as the scan collects them,
+// so even a big scan never holds them all in memory. It writes results
+// in the order they arrive rather than sorted.
+type resultStream struct {
+	f   *os.File
+	gz  *gzip.Writer
+	bw  *bufio.Writer
+	add func(scanner.ScanResult) error
+	end func() error // Writes whatever add held back
+	err error        // First write error; results after it are dropped
+}
+
+// Report whether the format of path can be written a result at a time:
+// JSON and XML are documents and need every result up front
+func streamable(path string) bool {
+	switch outputExt(path) {
+	case ".jsonl", ".csv", ".gnmap":
+		return true
+	}
+	return false
+}
+
+// Start a compressed stream of results in the format of path into f
+func newResultStream(f *os.File, path string) *resultStream {
+	st := &resultStream{f: f, gz: gzip.NewWriter(f)}
+	st.bw = bufio.NewWriter(st.gz)
+	st.end = func() error { return nil }
+	switch outputExt(path) {
+	case ".jsonl":
+		enc := json.NewEncoder(st.bw)
+		st.add = func(r scanner.ScanResult) error { return enc.Encode(r) }
+	case ".csv":
+		cw := csv.NewWriter(st.bw)
+		st.err = cw.Write(csvHeader)
+		st.add = func(r scanner.ScanResult) error { return cw.Write(csvRow(r)) }
+		st.end = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case ".gnmap":
+		// A host's line is written once a result for another host comes
+		// in, so a host whose results arrive interleaved with another's
+		// gets more than one
+		var held []scanner.ScanResult
+		st.add = func(r scanner.ScanResult) error {
+			var err error
+			if len(held) > 0 && (held[0].Target != r.Target || held[0].IP != r.IP) {
+				err = writeGrepable(st.bw, held)
+				held = held[:0]
+			}
+			held = append(held, r)
+			return err
+		}
+		st.end = func() error { return writeGrepable(st.bw, held) }
+	}
+	return st
+}
+
+// Add r to the stream
+func (st *resultStream) write(r scanner.ScanResult) {
+	if st.err == nil {
+		st.err = st.add(r)
+	}
+}
+
+// Finish the stream, flushing and closing the file, and return the first
+// error met along the way
+func (st *resultStream) close() error {
+	err := st.err
+	if err == nil {
+		err = st.end()
+	}
+	for _, done := range []func() error{st.bw.Flush, st.gz.Close, st.f.Close} {
+		if derr := done(); err == nil {
+			err = derr
+		}
+	}
+	return err
+}
+
+// Write the summary as one line for people, for stderr when stdout carries
+// machine-readable results
+func writeSummaryLine(w io.Writer, st scanner.Stats) {
+	counts := fmt.Sprintf("%d open, %d closed, %d filtered", st.Open, st.Closed, st.Filtered)
+	if st.OpenFiltered > 0 {
+		counts += fmt.Sprintf(", %d open|filtered", st.OpenFiltered)
+	}
+	line := fmt.Sprintf("portscan: %s of %d ports in %s", counts, st.Total, st.Elapsed.Round(time.Millisecond))
+	switch {
+	case st.TimedOut:
+		line += fmt.Sprintf(", time limit reached after %d tasks", st.Completed)
+	case st.Interrupted:
+		line += fmt.Sprintf(", interrupted after %d tasks", st.Completed)
+	case st.MaxResultsReached:
+		line += fmt.Sprintf(", stopped by -max-results after %d tasks", st.Completed)
+	}
+	if st.Unresolved > 0 {
+		line += fmt.Sprintf(", %d unresolved", st.Unresolved)
+	}
+	if b := scanTotal.Baseline; b != nil {
+		line += fmt.Sprintf(", %d new and %d missing against the baseline", b.New, len(b.Missing))
+	}
+	if n := len(scanTotal.SuspectHosts); n > 0 {
+		line += fmt.Sprintf(", %d hosts with unreliable results", n)
+	}
+	fmt.Fprintln(w, line)
+}
+
+// Print the end-of-scan summary. Host counts are only shown when
+// discovery ran, the hosts with the most dial timeouts when there were
+// timeouts, banner counts for hosts that sent more than one banner,
+// and hosts with unreliable-looking results when there are any.
+func printSummary(st scanner.Stats, discovery bool) {
+	fmt.Printf("\nScan Summary:\n")
+	if discovery {
+		fmt.Printf("  Hosts Up: %d\n", st.HostsUp)
+		fmt.Printf("  Hosts Down (not scanned): %d\n", st.HostsDown)
+	}
+	if st.Excluded > 0 {
+		fmt.Printf("  Hosts Excluded: %d\n", st.Excluded)
+	}
+	if st.Unresolved > 0 {
+		fmt.Printf("  Hosts Unresolved: %d\n", st.Unresolved)
+	}
+	if st.Skipped > 0 {
+		fmt.Printf("  Skipped After First Open: %d tasks\n", st.Skipped)
+	}
+	if st.DuplicateHosts > 0 || st.DuplicatePorts > 0 {
+		fmt.Printf("  Duplicates Skipped: %d targets, %d ports\n", st.DuplicateHosts, st.DuplicatePorts)
+	}
+	fmt.Printf("  Open Ports: %d\n", st.Open)
+	fmt.Printf("  Closed Ports: %d\n", st.Closed)
+	fmt.Printf("  Filtered Ports: %d\n", st.Filtered)
+	if st.OpenFiltered > 0 {
+		fmt.Printf("  Open|Filtered Ports: %d\n", st.OpenFiltered)
+	}
+	fmt.Printf("  Total Ports Scanned: %d\n", st.Total)
+	if st.DialAttempts > 0 {
+		fmt.Printf("  Dial Attempts: %d (%d failed, %d timed out, %d retries)\n", st.DialAttempts, st.DialFailures, st.DialTimeouts, st.DialRetries)
+	}
+	var slow []string // Hosts with timeouts, most first
+	for _, h := range scanTotal.HostDials {
+		if h.Timeouts > 0 {
+			slow = append(slow, fmt.Sprintf("%s (%d of %d timed out)", h.Host, h.Timeouts, h.Attempts))
+		}
+	}
+	if n := len(slow); n > 0 {
+		list := strings.Join(slow[:min(n, maxListed)], ", ")
+		if n > maxListed {
+			list += fmt.Sprintf(" and %d more", n-maxListed)
+		}
+		fmt.Printf("  Most Timeouts: %s\n", list)
+	}
+	switch {
+	case st.TimedOut:
+		fmt.Printf("  Time Limit Reached: %d of %d tasks completed, %d skipped\n", st.Completed, st.Total, st.Total-st.Completed)
+	case st.Interrupted:
+		fmt.Printf("  Interrupted: %d of %d tasks completed\n", st.Completed, st.Total)
+	case st.MaxResultsReached:
+		fmt.Printf("  Stopped at -max-results %d: %d of %d tasks completed\n", st.Open, st.Completed, st.Total)
+	}
+	fmt.Printf("  Time Taken: %s\n", st.Elapsed)
+	fmt.Printf("  Ports/sec: %.1f\n", st.PortsPerSec)
+	if st.Seed != 0 {
+		fmt.Printf("  Seed: %d\n", st.Seed)
+	}
+	if b := scanTotal.Baseline; b != nil {
+		fmt.Printf("  Baseline: %d new, %d expected, %d missing\n", b.New, b.Expected, len(b.Missing))
+		if n := len(b.Missing); n > 0 {
+			list := strings.Join(b.Missing[:min(n, maxListed)], ", ")
+			if n > maxListed {
+				list += fmt.Sprintf(" and %d more", n-maxListed)
+			}
+			fmt.Printf("  Expected But Not Open: %s\n", list)
+		}
+	}
+	if n := len(scanTotal.CappedHosts); n > 0 {
+		names := make([]string, 0, min(n, maxListed))
+		for _, h := range scanTotal.CappedHosts[:min(n, maxListed)] {
+			names = append(names, h.Target)
+		}
+		list := strings.Join(names, ", ")
+		if n > maxListed {
+			list += fmt.Sprintf(" and %d more", n-maxListed)
+		}
+		fmt.Printf("  Capped by -max-ports-per-host: %d hosts (%s)\n", n, list)
+	}
+	header := false
+	for _, c := range scanTotal.BannerCounts {
+		if c.Banners < 2 {
+			continue // Nothing to compare
+		}
+		if !header {
+			fmt.Printf("  Distinct Banners:\n")
+			header = true
+		}
+		host := c.Target
+		if c.IP != "" {
+			host += " (" + c.IP + ")"
+		}
+		fmt.Printf("    %s: %d of %d\n", host, c.Distinct, c.Banners)
+	}
+	if len(scanTotal.SuspectHosts) > 0 {
+		fmt.Printf("  Warning, results look unreliable for:\n")
+		for _, h := range scanTotal.SuspectHosts {
+			host := h.Target
+			if h.IP != "" {
+				host += " (" + h.IP + ")"
+			}
+			fmt.Printf("    %s: %s, %s\n", host, h.Reason, h.Detail)
+		}
+	}
+}
+
+// Hosts or ports named in a line of the text summary; JSON lists them all
+const maxListed = 10
+
+// Number of tasks -dry-run lists from each end of the task list
+const dryRunSample = 5
+
+// Print what -dry-run worked out: the numbers the scan would use and a
+// sample of its first and last tasks
+func printPlan(p scanner.Plan, discovery, shuffled bool) {
+	fmt.Printf("Scan Plan (dry run, nothing dialed):\n")
+	if p.Endpoints == 0 {
+		fmt.Printf("  Hosts: %d\n", p.Hosts)
+	}
+	if discovery {
+		fmt.Printf("  Discovery: on, hosts that don't answer will be skipped\n")
+	}
+	if p.Excluded > 0 {
+		fmt.Printf("  Hosts Excluded: %d\n", p.Excluded)
+	}
+	if p.Unresolved > 0 {
+		fmt.Printf("  Hosts Unresolved: %d\n", p.Unresolved)
+	}
+	if p.DuplicateHosts > 0 || p.DuplicatePorts > 0 {
+		fmt.Printf("  Duplicates Skipped: %d targets, %d ports\n", p.DuplicateHosts, p.DuplicatePorts)
+	}
+	if p.Endpoints > 0 {
+		fmt.Printf("  Endpoints: %d\n", p.Endpoints)
+	} else {
+		fmt.Printf("  Ports per Host: %d\n", p.Ports)
+	}
+	fmt.Printf("  Protocols: %s\n", strings.Join(p.Protocols, ", "))
+	fmt.Printf("  Total Tasks: %d\n", p.Tasks)
+	if p.HostConcurrency > 0 {
+		fmt.Printf("  Workers: %d per host, %d hosts at once\n", p.Workers, p.HostConcurrency)
+	} else {
+		fmt.Printf("  Workers: %d\n", p.Workers)
+	}
+	if p.Rate > 0 {
+		fmt.Printf("  Rate: %d/s\n", p.Rate)
+	} else {
+		fmt.Printf("  Rate: unlimited\n")
+	}
+	fmt.Printf("  Connect Timeout: %s\n", p.Timeout)
+	fmt.Printf("  Banner Timeout: %s\n", p.BannerTimeout)
+	fmt.Printf("  Retries: %d\n", p.Retries)
+	if p.PortBudget > 0 {
+		fmt.Printf("  Port Budget: %s\n", p.PortBudget)
+	}
+	fmt.Printf("  Max Open: %d\n", p.MaxOpen)
+	order := ""
+	if shuffled {
+		order = " (before -randomize shuffles them)"
+	}
+	for _, sample := range []struct {
+		name  string
+		tasks []scanner.Task
+	}{{"First", p.First}, {"Last", p.Last}} {
+		if len(sample.tasks) == 0 && sample.name == "Last" {
+			continue // Not worked out for this scan order
+		}
+		fmt.Printf("  %s %d Tasks%s:\n", sample.name, len(sample.tasks), order)
+		for _, t := range sample.tasks {
+			line := "    " + t.Proto + " " + t.Addr()
+			if t.IP != "" {
+				line += " (" + t.Target + ")"
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// Write the summary as a single JSON object, {"summary": {...}}
+func writeSummaryJSON(w io.Writer, st scanner.Stats) error {
+	sum := scanTotal // For the lists
+	sum.Stats, sum.Started, sum.Finished = st, scanRun.Started.UTC(), scanRun.Started.Add(st.Elapsed).UTC()
+	return json.NewEncoder(w).Encode(struct {
+		Summary scanSummary `json:"summary"`
+	}{sum})
+}
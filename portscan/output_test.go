@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+func TestWriteCSV(t *testing.T) {
+	results := []scanner.ScanResult{
+		{Target: "192.0.2.1", Port: 22, Proto: "tcp", State: "open", Service: "ssh", Banner: "SSH-2.0-OpenSSH_9.6", LatencyMs: 1.5},
+		{Target: "192.0.2.1", Port: 80, Proto: "tcp", State: "open", Service: "http", Banner: `HTTP/1.1 200 OK, "fine"` + "\nServer: test"},
+		{Target: "db1", IP: "192.0.2.2", Port: 5432, Proto: "tcp", State: "closed"},
+	}
+	var buf bytes.Buffer
+	if err := writeCSV(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if want := "target,port,state,service,banner,latency_ms,ip,proto,http_server,banner_encoding,baseline\n"; !strings.HasPrefix(out, want) {
+		t.Errorf("header = %q, want %q", strings.SplitN(out, "\n", 2)[0], strings.TrimSuffix(want, "\n"))
+	}
+	if want := `"HTTP/1.1 200 OK, ""fine""` + "\nServer: test\""; !strings.Contains(out, want) {
+		t.Errorf("banner with a comma, quotes and a newline not quoted as %q:\n%s", want, out)
+	}
+
+	rows, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		t.Fatalf("output doesn't parse back: %v", err)
+	}
+	want := [][]string{
+		csvHeader,
+		{"192.0.2.1", "22", "open", "ssh", "SSH-2.0-OpenSSH_9.6", "1.5", "", "tcp", "", "", ""},
+		{"192.0.2.1", "80", "open", "http", "HTTP/1.1 200 OK, \"fine\"\nServer: test", "", "", "tcp", "", "", ""},
+		{"db1", "5432", "closed", "", "", "", "192.0.2.2", "tcp", "", "", ""},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("got %d rows, want %d", len(rows), len(want))
+	}
+	for i := range want {
+		if !slices.Equal(rows[i], want[i]) {
+			t.Errorf("row %d = %q, want %q", i, rows[i], want[i])
+		}
+	}
+}
+
+func TestWriteGrepable(t *testing.T) {
+	results := []scanner.ScanResult{
+		{Target: "192.0.2.1", Port: 22, Proto: "tcp", State: "open", Service: "ssh"},
+		{Target: "db1", IP: "192.0.2.2", Port: 53, Proto: "udp", State: "open|filtered", Service: "domain"},
+		{Target: "192.0.2.1", Port: 80, Proto: "tcp", State: "closed", Service: "http"},
+		{Target: "db1", IP: "192.0.2.2", Port: 8000, Proto: "tcp", State: "open"},
+	}
+	var buf bytes.Buffer
+	if err := writeGrepable(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	want := "Host: 192.0.2.1 ()\tPorts: 22/open/tcp//ssh///, 80/closed/tcp//http///\n" +
+		"Host: 192.0.2.2 (db1)\tPorts: 53/open|filtered/udp//domain///, 8000/open/tcp/////\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestResultStream(t *testing.T) {
+	results := []scanner.ScanResult{
+		{Target: "192.0.2.1", Port: 22, Proto: "tcp", State: "open", Service: "ssh"},
+		{Target: "192.0.2.1", Port: 80, Proto: "tcp", State: "open", Service: "http"},
+		{Target: "db1", IP: "192.0.2.2", Port: 53, Proto: "udp", State: "open|filtered", Service: "domain"},
+		{Target: "192.0.2.1", Port: 443, Proto: "tcp", State: "open", Service: "https"},
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"scan.jsonl.gz", ""}, // Checked against writeJSONLines below
+		{"scan.csv.gz", "target,port,state,service,banner,latency_ms,ip,proto,http_server,banner_encoding,baseline\n" +
+			"192.0.2.1,22,open,ssh,,,,tcp,,,\n" +
+			"192.0.2.1,80,open,http,,,,tcp,,,\n" +
+			"db1,53,open|filtered,domain,,,192.0.2.2,udp,,,\n" +
+			"192.0.2.1,443,open,https,,,,tcp,,,\n"},
+		{"scan.gnmap.gz", "Host: 192.0.2.1 ()\tPorts: 22/open/tcp//ssh///, 80/open/tcp//http///\n" +
+			"Host: 192.0.2.2 (db1)\tPorts: 53/open|filtered/udp//domain///\n" +
+			"Host: 192.0.2.1 ()\tPorts: 443/open/tcp//https///\n"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		f, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := newResultStream(f, path)
+		for _, r := range results {
+			st.write(r)
+		}
+		if err := st.close(); err != nil {
+			t.Fatalf("%s: close: %v", tt.name, err)
+		}
+
+		raw, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(raw)
+		if err != nil {
+			t.Fatalf("%s: not gzipped: %v", tt.name, err)
+		}
+		got, err := io.ReadAll(zr)
+		raw.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := tt.want
+		if want == "" {
+			var buf bytes.Buffer
+			writeJSONLines(&buf, results)
+			want = buf.String()
+		}
+		if string(got) != want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, want)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// Width of the bar portion of the progress line
+const progressWidth = 30
+
+// Report whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Redraw a single progress line in place until stop is closed, then clear
+// it so the results start on a clean line. done is signalled on return.
+func showProgress(s *scanner.Scanner, stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			fmt.Print("\r\033[K")
+			return
+		case <-ticker.C:
+			fmt.Print("\r" + progressLine(s.Stats()))
+		}
+	}
+}
+
+// Render "[=====>    ] 120/1024  11.7%  3s  40.0/s  ETA 22s"
+func progressLine(st scanner.Stats) string {
+	pct := 100.0
+	if st.Total > 0 {
+		pct = float64(st.Completed) / float64(st.Total) * 100
+	}
+	filled := int(pct / 100 * progressWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressWidth {
+		bar += ">" + strings.Repeat(" ", progressWidth-filled-1)
+	}
+	line := fmt.Sprintf("[%s] %d/%d %5.1f%%  %s  %.1f/s", bar, st.Completed, st.Total, pct, st.Elapsed.Round(time.Second), st.PortsPerSec)
+	if st.ETA > 0 {
+		line += fmt.Sprintf("  ETA %s", st.ETA.Round(time.Second))
+	}
+	return line + "\033[K" // Clear leftovers when the line gets shorter
+}
@@ -0,0 +1,176 @@
+package scanner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Values of ScanResult.Baseline for open ports when Scanner.Baseline is set
+const (
+	BaselineExpected = "expected" // Listed in the baseline
+	BaselineNew      = "new"      // Open but not in the baseline
+)
+
+// Baseline is a set of ports expected to be open, for telling drift from
+// known services in recurring scans
+type Baseline struct {
+	entries []baselineEntry // In file order, for BaselineMissing
+	index   map[baselineEntry]bool
+}
+
+// baselineEntry is one expected port; proto is empty to match both
+type baselineEntry struct {
+	host, proto string
+	port        int
+}
+
+func (e baselineEntry) String() string {
+	s := net.JoinHostPort(e.host, strconv.Itoa(e.port))
+	if e.proto != "" {
+		s += "/" + e.proto
+	}
+	return s
+}
+
+// LoadBaseline reads the ports expected to be open from a JSON file:
+// either a list of "host:port" strings, each optionally followed by
+// "/tcp" or "/udp" (either protocol matches without it), or the JSON
+// output of an earlier scan, whose open ports become the baseline.
+//
+//	["10.0.0.5:22", "web1.example.com:443/tcp", "[2001:db8::1]:53/udp"]
+func LoadBaseline(path string) (*Baseline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Baseline{index: map[baselineEntry]bool{}}
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] == '{' {
+		var doc struct {
+			Results []ScanResult `json:"results"`
+		}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for _, r := range doc.Results {
+			if r.State == StateOpen {
+				host := r.Target
+				if r.IP != "" && len(r.IPs) == 0 {
+					host = r.IP // The name may resolve elsewhere next time; the address was what answered
+				}
+				b.add(baselineEntry{host: host, proto: r.Proto, port: r.Port})
+			}
+		}
+		return b, nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("%s: want a list of \"host:port\" strings or scan results: %v", path, err)
+	}
+	for _, s := range list {
+		e, err := parseBaselineEntry(s)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		b.add(e)
+	}
+	return b, nil
+}
+
+// Parse "host:port" or "host:port/proto"
+func parseBaselineEntry(s string) (baselineEntry, error) {
+	addr, proto, _ := strings.Cut(s, "/")
+	if proto != "" && proto != "tcp" && proto != "udp" {
+		return baselineEntry{}, fmt.Errorf("invalid baseline entry %q: protocol must be tcp or udp", s)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil || host == "" {
+		return baselineEntry{}, fmt.Errorf("invalid baseline entry %q: want host:port", s)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return baselineEntry{}, fmt.Errorf("invalid baseline entry %q: port must be between 1 and 65535", s)
+	}
+	return baselineEntry{host: host, proto: proto, port: port}, nil
+}
+
+func (b *Baseline) add(e baselineEntry) {
+	e.host = strings.ToLower(e.host)
+	if !b.index[e] {
+		b.index[e] = true
+		b.entries = append(b.entries, e)
+	}
+}
+
+// Len returns the number of expected ports
+func (b *Baseline) Len() int {
+	return len(b.entries)
+}
+
+// The entry r matches, by target or address and by protocol, and whether
+// there is one
+func (b *Baseline) match(r ScanResult) (baselineEntry, bool) {
+	for _, host := range []string{r.Target, r.IP} {
+		if host == "" {
+			continue
+		}
+		host = strings.ToLower(host)
+		for _, proto := range []string{r.Proto, ""} {
+			e := baselineEntry{host: host, proto: proto, port: r.Port}
+			if b.index[e] {
+				return e, true
+			}
+		}
+	}
+	return baselineEntry{}, false
+}
+
+// Contains reports whether r's port is in the baseline
+func (b *Baseline) Contains(r ScanResult) bool {
+	_, ok := b.match(r)
+	return ok
+}
+
+// Mark an open result as expected or new, noting which baseline entries
+// showed up. Called from the collector only.
+func (s *Scanner) checkBaseline(r *ScanResult) {
+	if s.Baseline == nil || r.State != StateOpen {
+		return
+	}
+	e, ok := s.Baseline.match(*r)
+	if !ok {
+		r.Baseline = BaselineNew
+		s.baselineNew++
+		return
+	}
+	r.Baseline = BaselineExpected
+	s.baselineExpected++
+	s.baselineSeen[e] = true
+}
+
+// BaselineCounts returns how many open results of the last scan were in
+// the Baseline and how many weren't. Call it once Scan has returned.
+func (s *Scanner) BaselineCounts() (expected, added int) {
+	return s.baselineExpected, s.baselineNew
+}
+
+// BaselineMissing returns the baseline entries that weren't found open in
+// the last scan, in the order of the baseline, as "host:port" or
+// "host:port/proto". A port the scan didn't cover counts as missing too.
+// Call it once Scan has returned.
+func (s *Scanner) BaselineMissing() []string {
+	if s.Baseline == nil {
+		return nil
+	}
+	var missing []string
+	for _, e := range s.Baseline.entries {
+		if !s.baselineSeen[e] {
+			missing = append(missing, e.String())
+		}
+	}
+	return missing
+}
@@ -0,0 +1,55 @@
+package scanner
+
+import (
+	"regexp"
+	"strings"
+)
+
+// bannerSignature recognizes one protocol from what a service says first
+type bannerSignature struct {
+	proto string
+	match func(banner string) bool
+}
+
+// Match the banner against a regular expression
+func matchRegexp(expr string) func(string) bool {
+	return regexp.MustCompile(expr).MatchString
+}
+
+// Signatures tried in order; the first match wins. SMTP comes before FTP
+// since both greet with "220".
+var bannerSignatures = []bannerSignature{
+	{"ssh", matchRegexp(`^SSH-\d\.\d+-`)},
+	{"http", matchRegexp(`^HTTP/\d(\.\d)? \d{3}`)},
+	{"smtp", matchRegexp(`(?i)^220[ -].*(E?SMTP|Postfix|Exim|Sendmail|mail)`)},
+	{"ftp", matchRegexp(`(?i)^220[ -].*(FTP|FileZilla|Pure-FTPd)`)},
+	{"mysql", isMySQLGreeting},
+	{"redis", matchRegexp(`^(-(ERR|NOAUTH|DENIED|WRONGPASS) |\+PONG\r\n|\$\d+\r\n# Server)`)},
+}
+
+// MySQL opens with a binary handshake packet: a 3-byte length, sequence
+// number 0, then protocol version 10 and a NUL-terminated server version.
+// A refused client gets an error packet (0xff) naming MySQL instead.
+func isMySQLGreeting(b string) bool {
+	if len(b) < 6 || b[3] != 0 {
+		return false
+	}
+	switch b[4] {
+	case 0x0a:
+		return b[5] >= '0' && b[5] <= '9'
+	case 0xff:
+		return strings.Contains(b, "MySQL") || strings.Contains(b, "MariaDB")
+	}
+	return false
+}
+
+// Identify the protocol a service speaks from its banner, regardless of
+// the port it runs on. Returns "" when nothing matches.
+func classifyBanner(banner string) string {
+	for _, sig := range bannerSignatures {
+		if sig.match(banner) {
+			return sig.proto
+		}
+	}
+	return ""
+}
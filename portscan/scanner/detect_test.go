@@ -0,0 +1,26 @@
+package scanner
+
+import "testing"
+
+func TestClassifyBanner(t *testing.T) {
+	tests := []struct {
+		banner string
+		want   string
+	}{
+		{"SSH-2.0-OpenSSH_8.9p1 Ubuntu-3\r\n", "ssh"},
+		{"HTTP/1.1 200 OK\r\nServer: nginx", "http"},
+		{"220 smtp.example.com ESMTP Postfix\r\n", "smtp"},
+		{"220 (vsFTPd 3.0.5)\r\n", "ftp"},
+		{"220-FileZilla Server 1.7\r\n", "ftp"},
+		{"J\x00\x00\x00\x0a8.0.36\x00", "mysql"},
+		{"-ERR unknown command 'HEAD'\r\n", "redis"},
+		{"-NOAUTH Authentication required.\r\n", "redis"},
+		{"hello\r\n", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := classifyBanner(tt.banner); got != tt.want {
+			t.Errorf("classifyBanner(%q) = %q, want %q", tt.banner, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultDiscoveryPorts are the ports tried when checking whether a host is up
+var DefaultDiscoveryPorts = []int{80, 443, 22, 445}
+
+// LiveHost is a host that answered discovery, with the round-trip time of
+// the ICMP echo or TCP connect that reached it
+type LiveHost struct {
+	Host string
+	RTT  time.Duration
+}
+
+// LiveHosts returns the hosts the last scan's discovery found up, in the
+// order they answered. Call it once Scan has returned.
+func (s *Scanner) LiveHosts() []LiveHost {
+	return s.live
+}
+
+// Report whether host answers, and how quickly: by ICMP echo when ping is
+// set and covers host's family, else by TCP ping
+func (s *Scanner) hostUp(ctx context.Context, ping *pinger, host string, ports []int) (time.Duration, bool) {
+	ip := net.ParseIP(host)
+	if ping == nil || ip == nil || !ping.supports(ip) {
+		return s.tcpPing(ctx, host, ports)
+	}
+	if s.wait(ctx) != nil {
+		return 0, false
+	}
+	return ping.ping(ctx, ip, s.dialer.Timeout)
+}
+
+// Report whether host answers a TCP connect on any of ports. A refused
+// connection counts too: the RST means something is there. All ports are
+// tried at once so a dead host costs one timeout, not one per port.
+func (s *Scanner) tcpPing(ctx context.Context, host string, ports []int) (time.Duration, bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	answers := make(chan bool, len(ports))
+	for _, port := range ports {
+		go func(port int) {
+			conn, err := s.dial(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+			}
+			answers <- err == nil || refused(err)
+		}(port)
+	}
+	for range ports {
+		if <-answers {
+			return time.Since(start), true // cancel aborts the remaining probes
+		}
+	}
+	return 0, false
+}
+
+// Probe every host in specs (and TargetsFile) and return the live ones as
+// single-host specs, counting live and dead hosts as it goes
+func (s *Scanner) discover(ctx context.Context, specs []targetSpec, workers int) ([]targetSpec, error) {
+	ports := s.DiscoveryPorts
+	if len(ports) == 0 {
+		ports = DefaultDiscoveryPorts
+	}
+	var ping *pinger
+	if s.DiscoverICMP {
+		var err error
+		if ping, err = newPinger(); err != nil {
+			s.log.Warn("ICMP discovery needs root or CAP_NET_RAW, falling back to TCP ping", "err", err)
+		} else {
+			defer ping.close()
+		}
+	}
+	s.live = nil
+
+	hosts := make(chan targetSpec, 256) // Single hosts, already past exclusions
+	var mu sync.Mutex
+	alive := []targetSpec{}
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for host := range hosts {
+				rtt, up := s.hostUp(ctx, ping, host.host, ports)
+				if !up {
+					if ctx.Err() == nil {
+						s.log.Debug("host down", "host", host.host)
+						s.hostsDown.Add(1)
+					}
+					continue
+				}
+				s.log.Debug("host up", "host", host.host, "rtt", rtt)
+				s.hostsUp.Add(1)
+				mu.Lock()
+				alive = append(alive, host)
+				s.live = append(s.live, LiveHost{Host: host.host, RTT: rtt})
+				mu.Unlock()
+			}
+		}()
+	}
+
+	send := func(t targetSpec) bool {
+		for _, r := range s.resolve(ctx, t) {
+			r.each(func(host string) bool {
+				select {
+				case hosts <- targetSpec{host: host, name: r.name}:
+					return true
+				case <-ctx.Done():
+					return false
+				}
+			})
+		}
+		return ctx.Err() == nil
+	}
+	var err error
+	for _, t := range specs {
+		if !send(t) {
+			break
+		}
+	}
+	if s.TargetsFile != "" && ctx.Err() == nil {
+		err = s.eachFileTarget(specs, send)
+	}
+	close(hosts)
+	wg.Wait()
+	return alive, err
+}
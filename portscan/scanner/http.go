@@ -0,0 +1,96 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ports where we send an HTTP request instead of waiting for the server
+// to talk first. TLS ports in this list get the request over TLS.
+var httpPorts = map[int]bool{
+	80: true, 81: true, 443: true, 591: true, 3128: true, 8000: true, 8008: true,
+	8080: true, 8081: true, 8443: true, 8888: true, 9443: true,
+}
+
+// Bytes of the body read looking for the page title
+const httpBodyBytes = 4096
+
+// HTTPInfo holds what an HTTP probe learned about a web server
+type HTTPInfo struct {
+	Status int    `json:"status" xml:"status"`                     // Status code of the response to GET /
+	Server string `json:"server,omitempty" xml:"server,omitempty"` // Server header
+	Title  string `json:"title,omitempty" xml:"title,omitempty"`   // <title> of the page, if in the first httpBodyBytes
+}
+
+// Send a minimal GET request and return the status line as the banner,
+// with the Server header if the response carried one, and the details of
+// the response. info is nil when the answer wasn't HTTP.
+func httpProbe(conn net.Conn, host string, port int, timeout time.Duration) (banner string, info *HTTPInfo) {
+	_, isTLS := conn.(*tls.Conn)
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := fmt.Fprintf(conn, "GET / HTTP/1.0\r\nHost: %s\r\n\r\n", hostHeader(host, port, isTLS)); err != nil {
+		return "", nil
+	}
+	br := bufio.NewReader(conn)
+	tp := textproto.NewReader(br)
+	status, err := tp.ReadLine()
+	if err != nil || !strings.HasPrefix(status, "HTTP/") {
+		return status, nil
+	}
+	info = &HTTPInfo{}
+	if f := strings.Fields(status); len(f) > 1 {
+		info.Status, _ = strconv.Atoi(f[1]) // HTTP/1.1 200 OK
+	}
+	header, err := tp.ReadMIMEHeader() // Keep whatever headers arrived before an error
+	info.Server = header.Get("Server")
+	banner = status
+	if info.Server != "" {
+		banner += "\r\nServer: " + info.Server
+	}
+	if err == nil {
+		body, _ := io.ReadAll(io.LimitReader(br, httpBodyBytes)) // Whatever arrives before the deadline
+		info.Title = htmlTitle(body)
+	}
+	return banner, info
+}
+
+// The Host header for host and port: IPv6 literals in brackets, and the
+// port left out when it's the scheme's default
+func hostHeader(host string, port int, isTLS bool) string {
+	if port == 80 && !isTLS || port == 443 && isTLS {
+		if strings.Contains(host, ":") {
+			return "[" + host + "]"
+		}
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// The text of the first <title> element in page, unescaped and with runs
+// of white space collapsed, or "" if there is none
+func htmlTitle(page []byte) string {
+	lower := bytes.ToLower(page)
+	start := bytes.Index(lower, []byte("<title"))
+	if start < 0 {
+		return ""
+	}
+	open := bytes.IndexByte(lower[start:], '>')
+	if open < 0 {
+		return ""
+	}
+	start += open + 1
+	end := bytes.Index(lower[start:], []byte("</title"))
+	if end < 0 {
+		return "" // Cut off; a partial title could mislead
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(page[start:start+end]))), " ")
+}
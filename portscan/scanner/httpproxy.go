@@ -0,0 +1,106 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ProxyRefusedError is returned when an HTTP proxy answers CONNECT with
+// anything but 200: its policy forbids the port, it wants credentials, or
+// it couldn't reach the target itself.
+type ProxyRefusedError struct {
+	Addr   string // Target the tunnel was asked for
+	Status string // Status line from the proxy, e.g. "403 Forbidden"
+	Code   int
+}
+
+func (e *ProxyRefusedError) Error() string {
+	return fmt.Sprintf("proxy refused CONNECT to %s: %s", e.Addr, e.Status)
+}
+
+// httpProxy opens TCP tunnels with HTTP CONNECT
+type httpProxy struct {
+	addr   string // host:port of the proxy
+	auth   string // Proxy-Authorization value, empty without credentials
+	dialer *net.Dialer
+}
+
+// Parse an http://[user:pass@]host:port proxy URL
+func newHTTPProxy(raw string, dialer *net.Dialer) (*httpProxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return nil, fmt.Errorf("invalid HTTP proxy %q: want http://[user:pass@]host:port", raw)
+	}
+	p := &httpProxy{addr: u.Host, dialer: dialer}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Host, "8080")
+	}
+	if u.User != nil {
+		pass, _ := u.User.Password()
+		p.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(u.User.Username()+":"+pass))
+	}
+	return p, nil
+}
+
+// DialContext connects to the proxy and asks it for a tunnel to addr. The
+// returned conn carries the tunnel, including anything the target sent
+// before the proxy's response was fully read.
+func (p *httpProxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := p.dialer.DialContext(ctx, "tcp", p.addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if p.auth != "" {
+		req.Header.Set("Proxy-Authorization", p.auth)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := func() (*http.Response, error) {
+		if err := req.Write(conn); err != nil {
+			return nil, err
+		}
+		return http.ReadResponse(br, req)
+	}()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, &ProxyRefusedError{Addr: addr, Status: resp.Status, Code: resp.StatusCode}
+	}
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads through r first, which holds bytes read past the end
+// of the proxy's response
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
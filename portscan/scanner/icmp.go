@@ -0,0 +1,147 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// pinger sends ICMP echo requests over privileged raw sockets, one per
+// address family, and hands each reply to the goroutine waiting for it.
+// Raw sockets see every ICMP packet the host receives, so replies are
+// matched on the echo ID and sequence number as well as the source.
+type pinger struct {
+	v4, v6 *icmp.PacketConn // nil when that family couldn't be opened
+	id     int
+	seq    atomic.Uint32
+
+	mu      sync.Mutex
+	waiting map[pingKey]chan struct{}
+}
+
+type pingKey struct {
+	ip  string
+	seq int
+}
+
+// Open raw ICMP sockets for both families. It fails only when neither
+// can be opened, typically because the process lacks root or CAP_NET_RAW.
+func newPinger() (*pinger, error) {
+	p := &pinger{id: os.Getpid() & 0xffff, waiting: map[pingKey]chan struct{}{}}
+	var err error
+	if p.v4, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0"); err != nil {
+		p.v4 = nil
+	}
+	var err6 error
+	if p.v6, err6 = icmp.ListenPacket("ip6:ipv6-icmp", "::"); err6 != nil {
+		p.v6 = nil
+	}
+	if p.v4 == nil && p.v6 == nil {
+		return nil, err
+	}
+	if p.v4 != nil {
+		go p.read(p.v4, ipv4.ICMPTypeEchoReply, 1)
+	}
+	if p.v6 != nil {
+		go p.read(p.v6, ipv6.ICMPTypeEchoReply, 58)
+	}
+	return p, nil
+}
+
+// Report whether echo requests can be sent to ip's family
+func (p *pinger) supports(ip net.IP) bool {
+	if ip.To4() != nil {
+		return p.v4 != nil
+	}
+	return p.v6 != nil
+}
+
+// Send one echo request to ip and wait up to timeout for the reply,
+// returning the round-trip time
+func (p *pinger) ping(ctx context.Context, ip net.IP, timeout time.Duration) (time.Duration, bool) {
+	conn, typ := p.v4, icmp.Type(ipv4.ICMPTypeEcho)
+	if ip.To4() == nil {
+		conn, typ = p.v6, ipv6.ICMPTypeEchoRequest
+	}
+	seq := int(p.seq.Add(1) & 0xffff)
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: p.id, Seq: seq, Data: []byte("portscan")}}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, false
+	}
+
+	key := pingKey{ip: ip.String(), seq: seq}
+	reply := make(chan struct{})
+	p.mu.Lock()
+	p.waiting[key] = reply
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.waiting, key)
+		p.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if _, err := conn.WriteTo(b, &net.IPAddr{IP: ip}); err != nil {
+		return 0, false
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-reply:
+		return time.Since(start), true
+	case <-t.C:
+	case <-ctx.Done():
+	}
+	return 0, false
+}
+
+// Read replies from conn until it is closed, waking the matching ping
+func (p *pinger) read(conn *icmp.PacketConn, want icmp.Type, proto int) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		msg, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || msg.Type != want {
+			continue
+		}
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || echo.ID != p.id {
+			continue
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		key := pingKey{ip: addr.IP.String(), seq: echo.Seq}
+		p.mu.Lock()
+		if reply, ok := p.waiting[key]; ok {
+			close(reply)
+			delete(p.waiting, key)
+		}
+		p.mu.Unlock()
+	}
+}
+
+func (p *pinger) close() {
+	if p.v4 != nil {
+		p.v4.Close()
+	}
+	if p.v6 != nil {
+		p.v6.Close()
+	}
+}
@@ -0,0 +1,72 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Sockets left for everything else when MaxOpen is derived from the file
+// descriptor limit: stdio, output files, the resolver and so on
+const fdReserve = 64
+
+// DefaultMaxOpen is the open-socket limit used when MaxOpen is zero: half
+// the process's file descriptor limit, less a small reserve
+func DefaultMaxOpen() int {
+	return max(fdLimit()/2-fdReserve, 16)
+}
+
+// Most sockets that can be open at once without running out of file
+// descriptors. The Go runtime already raises the soft limit to the hard
+// limit at startup, so this is as high as it gets without root.
+func fdBudget() int {
+	return max(fdLimit()-fdReserve, 16)
+}
+
+// Report whether a dial failed because this process, or the whole
+// system, ran out of file descriptors
+func outOfFDs(err error) bool {
+	return errors.Is(err, syscall.EMFILE) || errors.Is(err, syscall.ENFILE)
+}
+
+// Take an open-connection slot, waiting until one frees up. Returns false
+// if ctx is cancelled first.
+func (s *Scanner) acquireSlot(ctx context.Context) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Give back a slot taken by acquireSlot
+func (s *Scanner) releaseSlot() {
+	<-s.slots
+}
+
+// slotConn returns its open-connection slot when closed
+type slotConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+	latency time.Duration // How long the connect itself took
+}
+
+// Connect time of a conn from dial in milliseconds, to the microsecond
+func latencyMs(conn net.Conn) float64 {
+	c, ok := conn.(*slotConn)
+	if !ok {
+		return 0
+	}
+	return float64(c.latency.Microseconds()) / 1000
+}
+
+func (c *slotConn) Close() error {
+	err := c.Conn.Close()
+	c.once.Do(c.release)
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package scanner
+
+// Windows and friends have no RLIMIT_NOFILE, so assume a generous limit
+func fdLimit() int {
+	return 8192
+}
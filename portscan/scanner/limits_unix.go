@@ -0,0 +1,14 @@
+//go:build unix
+
+package scanner
+
+import "syscall"
+
+// Soft limit on open file descriptors for this process
+func fdLimit() int {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil || rl.Cur > 1<<20 {
+		return 1 << 20 // Unknown or effectively unlimited
+	}
+	return int(rl.Cur)
+}
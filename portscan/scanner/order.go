@@ -0,0 +1,57 @@
+package scanner
+
+// Orders in which a scan works through its tasks, for ScanOrder
+const (
+	OrderHost       = "host"       // Every port of a host before the next host
+	OrderPort       = "port"       // Every host on a port before the next port
+	OrderInterleave = "interleave" // Round-robin over a window of hosts
+)
+
+// Number of hosts interleaved at once. Tasks rotate among this many hosts
+// instead of all of them, so memory stays bounded for huge target lists.
+const interleaveWindow = 256
+
+// interleaver takes one task from each host in turn, so a slow host
+// doesn't hold up the rest. Once the window is full, tasks are emitted
+// until a host runs out and makes room for the next one.
+type interleaver struct {
+	hosts []func() (scanTask, bool)
+	next  int
+	out   func(scanTask) bool
+}
+
+// Add a host's tasks, given as an iterator. Returns false once out does,
+// meaning the feed should stop.
+func (il *interleaver) add(tasks func() (scanTask, bool)) bool {
+	il.hosts = append(il.hosts, tasks)
+	for len(il.hosts) >= interleaveWindow {
+		if !il.step() {
+			return false
+		}
+	}
+	return true
+}
+
+// Emit the next task of the next host in turn, dropping hosts with none
+// left
+func (il *interleaver) step() bool {
+	if il.next >= len(il.hosts) {
+		il.next = 0
+	}
+	t, ok := il.hosts[il.next]()
+	if !ok {
+		il.hosts = append(il.hosts[:il.next], il.hosts[il.next+1:]...)
+		return true
+	}
+	il.next++
+	return il.out(t)
+}
+
+// Emit every remaining task, still taking turns
+func (il *interleaver) flush() {
+	for len(il.hosts) > 0 {
+		if !il.step() {
+			return
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package scanner
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+)
+
+// Plan is what a scan would do, worked out without dialing anything
+type Plan struct {
+	Hosts     int      // Hosts to scan after exclusions and resolution; Discover may drop more
+	Ports     int      // Ports per host, without duplicates, for all protocols together
+	Protocols []string // Protocols scanned on each port, or on its own ports with PortsByProto
+	Endpoints int      // Host and port pairs from EndpointsFile
+	Tasks     int64    // Hosts × port and protocol pairs + endpoints × protocols
+
+	Excluded       int64 // Hosts removed by ExcludeHosts
+	Unresolved     int64 // Target hostnames whose lookup failed
+	DuplicateHosts int64 // Repeated or overlapping target entries dropped
+	DuplicatePorts int64 // Repeated ports dropped
+
+	// Effective settings, with defaults filled in
+	Workers         int           // Per host when HostConcurrency is set
+	HostConcurrency int           // Hosts scanned at once, 0 when all share Workers
+	Rate            int           // Connection attempts per second, 0 for unlimited
+	Timeout         time.Duration // For each connection attempt
+	BannerTimeout   time.Duration // For reading a banner once connected
+	Retries         int
+	PortBudget      time.Duration // For all of a port's attempts, 0 for none
+	MaxOpen         int
+
+	// First and Last sample the start and end of the task list, in the
+	// order tasks are generated before any Randomize shuffle. Last is only
+	// filled in for host order without PriorityPorts, where it can be found
+	// without generating every task.
+	First, Last []Task
+}
+
+// Task is a single host, port and protocol a scan would try
+type Task struct {
+	Target string // Host as given, or the hostname it was resolved from
+	IP     string // Address scanned when Target is a hostname
+	Port   int
+	Proto  string
+}
+
+// Addr returns the task's address as host:port, bracketing IPv6 hosts
+func (t Task) Addr() string {
+	host := t.Target
+	if t.IP != "" {
+		host = t.IP
+	}
+	return net.JoinHostPort(host, strconv.Itoa(t.Port))
+}
+
+// Plan expands the targets and ports exactly as Scan would, resolving
+// hostnames but dialing nothing, and reports the task count, the effective
+// settings and up to sample tasks from each end of the task list.
+// Discovery is not run, so Hosts counts every host it would probe.
+func (s *Scanner) Plan(ctx context.Context, sample int) (Plan, error) {
+	s.resetStats()
+	defer s.finished.Store(time.Now().UnixNano())
+	setup, err := s.prepare(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	p := Plan{
+		Hosts:           setup.hosts,
+		Ports:           len(s.ports),
+		Protocols:       setup.protos,
+		Endpoints:       setup.endpoints,
+		Tasks:           setup.tasks(),
+		Excluded:        s.hostsExcluded.Load(),
+		Unresolved:      s.unresolved.Load(),
+		DuplicateHosts:  s.duplicateHosts.Load(),
+		DuplicatePorts:  s.duplicatePorts.Load(),
+		Workers:         setup.workers,
+		HostConcurrency: s.HostConcurrency,
+		Rate:            s.Rate,
+		Timeout:         s.dialer.Timeout,
+		BannerTimeout:   s.bannerTimeout,
+		Retries:         s.retries,
+		PortBudget:      s.PortBudget,
+		MaxOpen:         cap(s.slots),
+	}
+	if sample <= 0 {
+		return p, nil
+	}
+	if s.ScanOrder != "" && s.ScanOrder != OrderHost || s.priority > 0 {
+		ctx, cancel := context.WithCancel(ctx) // Also releases any StopOnFirstOpen host contexts
+		defer cancel()
+		s.state = nil
+		s.generate(ctx, setup.specs, setup.fromFile, s.ports, setup.protos, func(t scanTask) bool {
+			p.First = append(p.First, t.task())
+			return len(p.First) < sample
+		})
+		return p, ctx.Err()
+	}
+	if s.EndpointsFile != "" {
+		return p, s.sampleEndpoints(ctx, &p, sample, setup.protos)
+	}
+	perHost := setup.pairs
+	if perHost == 0 {
+		return p, nil
+	}
+	hostsNeeded := (sample + perHost - 1) / perHost
+
+	// One pass over the targets fills First and remembers the last few
+	// specs, whose hosts are then walked backwards for Last. Huge blocks
+	// are never enumerated in full.
+	var tail []targetSpec
+	visit := func(t targetSpec) bool {
+		for _, r := range s.resolve(ctx, t) {
+			if len(p.First) < sample {
+				r.each(func(host string) bool {
+					p.First = append(p.First, s.capTasks(r.tasks(host, s.ports, setup.protos, s.scans))...)
+					return len(p.First) < sample
+				})
+			}
+			if tail = append(tail, r); len(tail) > hostsNeeded {
+				tail = tail[1:]
+			}
+		}
+		return ctx.Err() == nil
+	}
+	for _, t := range setup.specs {
+		if !visit(t) {
+			break
+		}
+	}
+	if setup.fromFile && ctx.Err() == nil {
+		if err := s.eachFileTarget(setup.specs, visit); err != nil {
+			return p, err
+		}
+	}
+	p.First = p.First[:min(sample, len(p.First))]
+	for i := len(tail) - 1; i >= 0 && len(p.Last) < sample; i-- {
+		var tasks []Task
+		for _, host := range tail[i].lastHosts(hostsNeeded) {
+			tasks = append(tasks, s.capTasks(tail[i].tasks(host, s.ports, setup.protos, s.scans))...)
+		}
+		p.Last = append(tasks, p.Last...)
+	}
+	p.Last = p.Last[max(0, len(p.Last)-sample):]
+	return p, ctx.Err()
+}
+
+// Fill in the samples for an EndpointsFile scan. The file is streamed
+// once, keeping only the latest tasks for Last.
+func (s *Scanner) sampleEndpoints(ctx context.Context, p *Plan, sample int, protos []string) error {
+	err := eachEndpointInFile(s.EndpointsFile, s.targetOpts, func(t targetSpec, ports []int) bool {
+		for _, r := range s.resolve(ctx, t) {
+			r.each(func(host string) bool {
+				tasks := r.tasks(host, ports, protos, nil)
+				if n := sample - len(p.First); n > 0 {
+					p.First = append(p.First, tasks[:min(n, len(tasks))]...)
+				}
+				if p.Last = append(p.Last, tasks...); len(p.Last) > sample {
+					p.Last = p.Last[len(p.Last)-sample:]
+				}
+				return true
+			})
+		}
+		return ctx.Err() == nil
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// The Task that t would scan
+func (t scanTask) task() Task {
+	host, port, _ := net.SplitHostPort(t.Addr)
+	n, _ := strconv.Atoi(port)
+	if t.Name != "" {
+		return Task{Target: t.Name, IP: host, Port: n, Proto: t.Proto}
+	}
+	return Task{Target: host, Port: n, Proto: t.Proto}
+}
+
+// The first MaxPortsPerHost of one host's tasks, or all of them without a cap
+func (s *Scanner) capTasks(tasks []Task) []Task {
+	if s.MaxPortsPerHost > 0 && len(tasks) > s.MaxPortsPerHost {
+		return tasks[:s.MaxPortsPerHost]
+	}
+	return tasks
+}
+
+// Tasks for one host of the spec, in the order feed generates them,
+// leaving out the pairs keep rejects if it isn't nil
+func (t targetSpec) tasks(host string, ports []int, protos []string, keep func(proto string, port int) bool) []Task {
+	target, ip := host, ""
+	if t.name != "" {
+		target, ip = t.name, host
+	}
+	tasks := make([]Task, 0, len(ports)*len(protos))
+	for _, port := range ports {
+		for _, p := range protos {
+			if keep != nil && !keep(p, port) {
+				continue
+			}
+			tasks = append(tasks, Task{Target: target, IP: ip, Port: port, Proto: p})
+		}
+	}
+	return tasks
+}
@@ -0,0 +1,135 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expand a single port token: "N", an inclusive range "N-M", or a service
+// name like "https"
+func parsePortToken(tok string) ([]int, error) {
+	if tok != "" && !unicode.IsDigit(rune(tok[0])) {
+		// Names can contain dashes (ftp-data), so try them before ranges
+		port, ok := LookupPort(tok)
+		if !ok {
+			return nil, fmt.Errorf("invalid port %q: not a number or known service name", tok)
+		}
+		return []int{port}, nil
+	}
+	lo, hi, isRange := strings.Cut(tok, "-")
+	if !isRange {
+		val, err := strconv.Atoi(tok)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q: not a number", tok)
+		}
+		if val < 1 || val > 65535 {
+			return nil, fmt.Errorf("invalid port %q: must be between 1 and 65535", tok)
+		}
+		return []int{val}, nil
+	}
+	start, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	end, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if err1 != nil || err2 != nil {
+		return nil, fmt.Errorf("invalid port range %q", tok)
+	}
+	if start < 1 || end > 65535 || start > end {
+		return nil, fmt.Errorf("invalid port range %q: must satisfy 1 <= N <= M <= 65535", tok)
+	}
+	return PortRange(start, end), nil
+}
+
+// ParsePorts parses a comma-separated list of ports, ranges and service
+// names such as "22,https,8000-8100" into the ports to scan. Any bad token is an error
+// naming that token, rather than being skipped.
+func ParsePorts(list string) ([]int, error) {
+	ports := []int{}
+	for _, p := range strings.Split(list, ",") {
+		expanded, err := parsePortToken(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, expanded...)
+	}
+	return ports, nil
+}
+
+// ParsePortsFile reads ports from a file with one ParsePorts list per line,
+// so a line can hold a single port, a range, a service name or several of
+// them separated by commas. Blank lines and # comments are skipped, and a
+// bad line is reported with its line number.
+func ParsePortsFile(path string) ([]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ports := []int{}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		entry, _, _ := strings.Cut(sc.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		expanded, err := ParsePorts(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		ports = append(ports, expanded...)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(ports) == 0 {
+		return nil, fmt.Errorf("%s: no ports listed", path)
+	}
+	return ports, nil
+}
+
+// PortRange returns every port from start to end inclusive, or nothing if
+// end comes before start
+func PortRange(start, end int) []int {
+	if end < start {
+		return nil
+	}
+	ports := make([]int, 0, end-start+1)
+	for p := start; p <= end; p++ {
+		ports = append(ports, p)
+	}
+	return ports
+}
+
+// Drop repeated ports, keeping the first occurrence of each, and report
+// how many were dropped
+func dedupePorts(ports []int) ([]int, int) {
+	seen := make(map[int]bool, len(ports))
+	kept := make([]int, 0, len(ports))
+	for _, p := range ports {
+		if !seen[p] {
+			seen[p] = true
+			kept = append(kept, p)
+		}
+	}
+	return kept, len(ports) - len(kept)
+}
+
+// ExcludePorts returns ports with every port in exclude removed, keeping
+// the original order
+func ExcludePorts(ports, exclude []int) []int {
+	skip := make(map[int]bool, len(exclude))
+	for _, p := range exclude {
+		skip[p] = true
+	}
+	kept := make([]int, 0, len(ports))
+	for _, p := range ports {
+		if !skip[p] {
+			kept = append(kept, p)
+		}
+	}
+	return kept
+}
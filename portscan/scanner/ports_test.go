@@ -0,0 +1,144 @@
+package scanner
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParsePorts(t *testing.T) {
+	tests := []struct {
+		list    string
+		want    []int
+		wantErr bool
+	}{
+		{list: "22", want: []int{22}},
+		{list: "22,80,443", want: []int{22, 80, 443}},
+		{list: " 22 , 80 ", want: []int{22, 80}},
+		{list: "20-22", want: []int{20, 21, 22}},
+		{list: "1-1", want: []int{1}},
+		{list: "22,8000-8002", want: []int{22, 8000, 8001, 8002}},
+		{list: "65535", want: []int{65535}},
+		{list: "ssh,https", want: []int{22, 443}},
+		{list: "HTTP", want: []int{80}},
+		{list: "ftp-data,21", want: []int{20, 21}},
+		{list: "rdp", want: []int{3389}},
+		{list: "", wantErr: true},
+		{list: "0", wantErr: true},
+		{list: "65536", wantErr: true},
+		{list: "-1", wantErr: true},
+		{list: "abc", wantErr: true},
+		{list: "22,", wantErr: true},
+		{list: "10-5", wantErr: true},
+		{list: "1-", wantErr: true},
+		{list: "1-70000", wantErr: true},
+		{list: "22,nosuchservice", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePorts(tt.list)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePorts(%q) = %v, want an error", tt.list, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePorts(%q): %v", tt.list, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("ParsePorts(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortsFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	good := write("web.txt", "# web ports\n80\n\nhttps, 8000-8002 # dev servers\n")
+	got, err := ParsePortsFile(good)
+	if err != nil {
+		t.Fatalf("ParsePortsFile: %v", err)
+	}
+	if want := []int{80, 443, 8000, 8001, 8002}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	bad := write("bad.txt", "22\n\nnotaport\n")
+	if _, err := ParsePortsFile(bad); err == nil || !strings.Contains(err.Error(), "bad.txt:3:") {
+		t.Errorf("got error %v, want one naming line 3", err)
+	}
+	if _, err := ParsePortsFile(write("empty.txt", "# nothing\n")); err == nil {
+		t.Error("ParsePortsFile accepted a file with no ports")
+	}
+}
+
+func TestPortRange(t *testing.T) {
+	if got, want := PortRange(1, 3), []int{1, 2, 3}; !slices.Equal(got, want) {
+		t.Errorf("PortRange(1, 3) = %v, want %v", got, want)
+	}
+	if got := PortRange(2000, 10); len(got) != 0 {
+		t.Errorf("PortRange(2000, 10) = %v, want nothing", got)
+	}
+	for _, port := range []int{0, 65536} {
+		s := &Scanner{Targets: []string{"192.0.2.1"}, Ports: []int{80, port}}
+		if _, err := s.Plan(context.Background(), 0); err == nil {
+			t.Errorf("Plan accepted port %d", port)
+		}
+	}
+}
+
+func TestLoadServices(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "services")
+	os.WriteFile(path, []byte(strings.Join([]string{
+		"# local services",
+		"myapp\t41234/tcp\t0.001\t# internal API",
+		"gitssh 22/tcp",
+		"myapp-udp 41234/udp",
+		"unknown 41236/tcp",
+		"sctpsvc 41237/sctp",
+		"broken",
+		"bad 70000/tcp",
+		"",
+	}, "\n")), 0o644)
+	old := LookupService(22, "tcp")
+	t.Cleanup(func() {
+		serviceNames["tcp"][22] = old
+		delete(serviceNames["tcp"], 41234)
+		delete(serviceNames["udp"], 41234)
+	})
+
+	var warnings []error
+	n, err := LoadServices(path, func(err error) { warnings = append(warnings, err) })
+	if err != nil {
+		t.Fatalf("LoadServices: %v", err)
+	}
+	if n != 3 || len(warnings) != 2 {
+		t.Errorf("got %d entries and warnings %v, want 3 entries and 2 warnings", n, warnings)
+	}
+	for _, c := range []struct {
+		port  int
+		proto string
+		want  string
+	}{{41234, "tcp", "myapp"}, {22, "tcp", "gitssh"}, {41234, "udp", "myapp-udp"}, {41236, "tcp", ""}} {
+		if got := LookupService(c.port, c.proto); got != c.want {
+			t.Errorf("LookupService(%d, %s) = %q, want %q", c.port, c.proto, got, c.want)
+		}
+	}
+	if port, ok := LookupPort("myapp"); !ok || port != 41234 {
+		t.Errorf("LookupPort(myapp) = %d, %v, want 41234", port, ok)
+	}
+	if port, ok := LookupPort("ssh"); !ok || port != 22 {
+		t.Errorf("LookupPort(ssh) = %d, %v, want the built-in name to keep working", port, ok)
+	}
+}
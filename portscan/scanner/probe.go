@@ -0,0 +1,260 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UDP payloads for services that only answer a well-formed request
+var udpPayloads = map[int][]byte{
+	// DNS query for the root NS records
+	53: {0x12, 0x34, 0x01, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00, 0x01},
+	// NTP v3 client request
+	123: append([]byte{0x1b}, make([]byte, 47)...),
+	// SNMPv1 get-request for sysDescr.0 with community "public"
+	161: {0x30, 0x26, 0x02, 0x01, 0x00, 0x04, 0x06, 0x70, 0x75, 0x62, 0x6c, 0x69, 0x63,
+		0xa0, 0x19, 0x02, 0x01, 0x01, 0x02, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x30, 0x0e, 0x30, 0x0c, 0x06, 0x08, 0x2b, 0x06, 0x01, 0x02, 0x01, 0x01, 0x01, 0x00, 0x05, 0x00},
+}
+
+// Banner read limits used when the corresponding Scanner field is zero
+const (
+	DefaultBannerBytes   = 1024
+	DefaultBannerTimeout = 2 * time.Second
+	MaxBannerBytes       = 64 << 10 // Hard cap on a banner, however chatty the service
+)
+
+// BannerGrab attempts to read a banner from an open connection
+func BannerGrab(conn net.Conn) string {
+	return grabBanner(conn, DefaultBannerBytes, DefaultBannerTimeout, false)
+}
+
+// How long a banner read waits for more data once some has arrived, so a
+// greeting split over several packets comes through whole without every
+// banner waiting out the full timeout
+const bannerIdle = 250 * time.Millisecond
+
+// Read a banner of up to size bytes, reading until the service goes quiet
+// for bannerIdle, or, with full, until EOF, the deadline or
+// MaxBannerBytes so multi-line greetings come through whole. Whatever
+// arrived before a timeout or error is kept.
+func grabBanner(conn net.Conn, size int, timeout time.Duration, full bool) string {
+	deadline := time.Now().Add(timeout)
+	conn.SetReadDeadline(deadline) // Set read timeout
+	limit := size
+	if full {
+		limit = MaxBannerBytes
+	}
+	buf := make([]byte, size)
+	var banner []byte
+	for len(banner) < limit {
+		n, err := conn.Read(buf[:min(size, limit-len(banner))])
+		banner = append(banner, buf[:n]...)
+		if err != nil {
+			break // EOF or the deadline: keep what came before it
+		}
+		if !full && n > 0 {
+			if idle := time.Now().Add(bannerIdle); idle.Before(deadline) {
+				conn.SetReadDeadline(idle)
+			}
+		}
+	}
+	return string(banner)
+}
+
+// Probe a UDP port by sending a payload and waiting for any reply.
+// A reply means open, an ICMP port unreachable (seen as ECONNREFUSED on a
+// connected socket) means closed, and silence is open|filtered.
+func (s *Scanner) udpProbe(ctx context.Context, addr string, port int) (state, banner string, ok bool) {
+	conn, err := s.dial(ctx, "udp", addr)
+	if err != nil {
+		s.log.Debug("dial failed", "addr", addr, "proto", "udp", "err", err)
+		return "", "", false // Unresolvable host or no route
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(s.dialer.Timeout))
+	payload, ok := s.Probes[port]
+	if !ok {
+		payload = udpPayloads[port]
+	}
+	if _, err := conn.Write(payload); err != nil {
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return StateClosed, "", true
+		}
+		return "", "", false
+	}
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	switch {
+	case n > 0:
+		return StateOpen, string(buf[:n]), true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return StateClosed, "", true
+	default:
+		return StateOpenFiltered, "", true
+	}
+}
+
+// Report whether a dial failed because the target refused it, either with
+// a local RST or as a SOCKS5 "connection refused" reply from the proxy
+func refused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) ||
+		err != nil && strings.HasSuffix(err.Error(), "unknown error connection refused")
+}
+
+// Map a failed TCP dial to a port state: an explicit refusal (RST) means
+// closed, anything else (timeouts, dropped packets) is treated as filtered
+func classifyDialError(err error) string {
+	if refused(err) {
+		return StateClosed
+	}
+	return StateFiltered
+}
+
+// What each UDP probe outcome says about the port
+var udpReasons = map[string]string{
+	StateOpen:         ReasonUDPResponse,
+	StateClosed:       ReasonPortUnreach,
+	StateOpenFiltered: ReasonNoResponse,
+}
+
+// Explain a failed TCP dial for ScanResult.Reason
+func dialReason(err error) string {
+	var netErr net.Error
+	var refusal *ProxyRefusedError
+	switch {
+	case errors.As(err, &refusal):
+		return ReasonProxyRefused
+	case refused(err):
+		return ReasonConnRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ReasonNoResponse
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return ReasonHostUnreach
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ReasonNetUnreach
+	}
+	return ReasonError
+}
+
+// Report whether a dial got no answer before its timeout
+func timedOut(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() || errors.Is(err, syscall.ETIMEDOUT)
+}
+
+// Report whether a failed dial is worth another attempt: timeouts and
+// transient socket errors are, a refused connection or a bad name is not
+func retryable(err error) bool {
+	if refused(err) {
+		return false
+	}
+	if timedOut(err) {
+		return true
+	}
+	var sysErr *os.SyscallError
+	if errors.As(err, &sysErr) {
+		switch sysErr.Err {
+		case syscall.ECONNRESET, syscall.ECONNABORTED, syscall.ETIMEDOUT, syscall.EAGAIN,
+			syscall.ENOBUFS, syscall.EMFILE, syscall.ENFILE, syscall.EHOSTUNREACH, syscall.ENETUNREACH:
+			return true
+		}
+	}
+	return false
+}
+
+// Read a banner from conn into r. A payload from Probes is sent first if
+// the port has one; otherwise HTTP is spoken first on web ports and, with
+// HTTPProbe, on any port that stays silent.
+func (s *Scanner) readBanner(conn net.Conn, r *ScanResult) {
+	if steps, ok := s.ProbeScripts[r.Port]; ok {
+		r.Banner = s.runScript(conn, steps)
+		return
+	}
+	if payload, ok := s.Probes[r.Port]; ok {
+		conn.SetWriteDeadline(time.Now().Add(s.bannerTimeout))
+		if _, err := conn.Write(payload); err == nil {
+			r.Banner = grabBanner(conn, s.bannerBytes, s.bannerTimeout, s.BannerFull)
+		}
+		return
+	}
+	if httpPorts[r.Port] {
+		s.probeHTTP(conn, r)
+		return
+	}
+	r.Banner = grabBanner(conn, s.bannerBytes, s.bannerTimeout, s.BannerFull)
+	if r.Banner == "" && s.HTTPProbe {
+		s.probeHTTP(conn, r)
+	}
+}
+
+// Speak HTTP on conn, filling in r's banner and HTTP details
+func (s *Scanner) probeHTTP(conn net.Conn, r *ScanResult) {
+	r.Banner, r.HTTP = httpProbe(conn, r.Target, r.Port, s.bannerTimeout)
+	r.HTTPServer = ""
+	if r.HTTP != nil {
+		r.HTTPServer = r.HTTP.Server
+	}
+}
+
+// Run a probe script over conn, sending each step and reading the reply
+// after it, and return everything read. Stops early when a write fails,
+// since the service has hung up, or once MaxBannerBytes is reached.
+func (s *Scanner) runScript(conn net.Conn, steps [][]byte) string {
+	var banner strings.Builder
+	for _, step := range steps {
+		if len(step) > 0 {
+			conn.SetWriteDeadline(time.Now().Add(s.bannerTimeout))
+			if _, err := conn.Write(step); err != nil {
+				break
+			}
+		}
+		banner.WriteString(grabBanner(conn, s.bannerBytes, s.bannerTimeout, s.BannerFull))
+		if banner.Len() >= MaxBannerBytes {
+			break
+		}
+	}
+	return banner.String()[:min(banner.Len(), MaxBannerBytes)]
+}
+
+// Grab whatever an open TCP port tells us: a TLS certificate on known TLS
+// ports (or when the plain banner looks binary), otherwise a plain banner.
+// conn is consumed; extra connections are dialed when a retry is needed.
+func (s *Scanner) inspectOpen(ctx context.Context, conn net.Conn, addr string, r *ScanResult) {
+	if !tlsPorts[r.Port] {
+		s.readBanner(conn, r)
+		conn.Close()
+		if r.Banner == "" || isPrintable(r.Banner) {
+			return
+		}
+		// Binary garbage, try again speaking TLS
+		if conn, err := s.dial(ctx, "tcp", addr); err == nil {
+			defer conn.Close()
+			if tc, info, err := tlsHandshake(conn, r.Target, s.dialer.Timeout); err == nil {
+				r.TLS = info
+				s.readBanner(tc, r)
+			}
+		}
+		return
+	}
+
+	tc, info, err := tlsHandshake(conn, r.Target, s.dialer.Timeout)
+	if err == nil {
+		defer tc.Close()
+		r.TLS = info
+		s.readBanner(tc, r)
+		return
+	}
+	conn.Close()
+	// Not TLS after all, fall back to a plain read on a fresh connection
+	if conn, err := s.dial(ctx, "tcp", addr); err == nil {
+		defer conn.Close()
+		s.readBanner(conn, r)
+	}
+}
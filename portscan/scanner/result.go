@@ -0,0 +1,102 @@
+package scanner
+
+// Port states reported in ScanResult
+const (
+	StateOpen         = "open"
+	StateClosed       = "closed"
+	StateFiltered     = "filtered"
+	StateOpenFiltered = "open|filtered" // UDP port that stayed silent
+)
+
+// BannerEncodingHex marks a ScanResult.Banner that was hex-encoded
+const BannerEncodingHex = "hex"
+
+// Reasons reported in ScanResult, saying what decided the state
+const (
+	ReasonSynAck       = "syn-ack"       // TCP handshake completed
+	ReasonConnRefused  = "conn-refused"  // RST in reply to the SYN
+	ReasonNoResponse   = "no-response"   // Timed out without an answer
+	ReasonHostUnreach  = "host-unreach"  // ICMP host unreachable
+	ReasonNetUnreach   = "net-unreach"   // ICMP network unreachable or no route
+	ReasonUDPResponse  = "udp-response"  // UDP reply received
+	ReasonPortUnreach  = "port-unreach"  // ICMP port unreachable for a UDP probe
+	ReasonProxyRefused = "proxy-refused" // HTTP proxy wouldn't open a tunnel
+	ReasonError        = "error"         // Some other dial failure
+)
+
+// ScanResult holds the result of a single port scan
+type ScanResult struct {
+	Target         string   `json:"target" xml:"target,attr"`
+	IP             string   `json:"ip,omitempty" xml:"ip,attr,omitempty"` // Address scanned when Target is a hostname
+	Port           int      `json:"port" xml:"port,attr"`
+	Proto          string   `json:"proto" xml:"proto,attr"`
+	State          string   `json:"state" xml:"state,attr"`
+	Service        string   `json:"service,omitempty" xml:"service,attr,omitempty"`                 // Well-known service name for the port
+	Banner         string   `json:"banner,omitempty" xml:"banner,omitempty"`                        // Optional banner if available
+	BannerEncoding string   `json:"banner_encoding,omitempty" xml:"banner_encoding,attr,omitempty"` // BannerEncodingHex if Banner is hex-encoded, see Scanner.BannerHex
+	HTTPServer     string   `json:"http_server,omitempty" xml:"http_server,omitempty"`              // Server header from an HTTP probe
+	TLS            *TLSInfo `json:"tls,omitempty" xml:"tls,omitempty"`                              // Certificate details for TLS services
+
+	DetectedProtocol string `json:"detected_protocol,omitempty" xml:"detected_protocol,attr,omitempty"` // Protocol recognized from the banner
+	Reason           string `json:"reason,omitempty" xml:"reason,attr,omitempty"`                       // Why the port is in its state
+
+	// HTTP has the status code, Server header and page title when an HTTP
+	// probe got an HTTP answer, for telling web servers apart; HTTPServer
+	// repeats the Server header
+	HTTP *HTTPInfo `json:"http,omitempty" xml:"http,omitempty"`
+
+	// LatencyMs is how long the successful TCP connect took, in
+	// milliseconds: through the proxy when there is one, and for the last
+	// attempt if earlier ones were retried. Zero for other results.
+	LatencyMs float64 `json:"latency_ms,omitempty" xml:"latency_ms,attr,omitempty"`
+
+	// Probes is how many times the port was scanned, when
+	// Scanner.ProbeCount is more than 1, and Hits how many of those found
+	// it open: a confidence figure for ports on lossy links. Both are zero
+	// otherwise.
+	Probes int `json:"probes,omitempty" xml:"probes,attr,omitempty"`
+	Hits   int `json:"hits,omitempty" xml:"hits,attr,omitempty"`
+
+	// Baseline is BaselineExpected or BaselineNew for open ports when
+	// Scanner.Baseline is set, and empty otherwise
+	Baseline string `json:"baseline,omitempty" xml:"baseline,attr,omitempty"`
+
+	// IPs lists every address of Target that gave this same result, set
+	// only on results merged by CollapseIPs; IP is the first of them
+	IPs []string `json:"ips,omitempty" xml:"-"`
+}
+
+// CollapseIPs merges the results for one hostname that differ only in the
+// address scanned, as with ResolveAll behind a load balancer: the same
+// port, protocol, state, service and banner. Each merged result keeps the
+// details of the first one and lists every contributing address in IPs,
+// in the order seen. Results for IP targets, and ones that differ, are
+// kept as they are.
+func CollapseIPs(results []ScanResult) []ScanResult {
+	type key struct {
+		target, proto, state, service, banner string
+		port                                  int
+	}
+	out := make([]ScanResult, 0, len(results))
+	seen := map[key]int{} // Index in out
+	for _, r := range results {
+		if r.IP == "" {
+			out = append(out, r)
+			continue
+		}
+		k := key{r.Target, r.Proto, r.State, r.Service, r.Banner, r.Port}
+		if i, ok := seen[k]; ok {
+			out[i].IPs = append(out[i].IPs, r.IP)
+			continue
+		}
+		seen[k] = len(out)
+		r.IPs = []string{r.IP}
+		out = append(out, r)
+	}
+	for i := range out {
+		if len(out[i].IPs) == 1 {
+			out[i].IPs = nil // Nothing was merged
+		}
+	}
+	return out
+}
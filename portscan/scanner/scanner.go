@@ -0,0 +1,1466 @@
+// Package scanner implements a concurrent TCP/UDP port scanner with banner
+// grabbing, usable both from the portscan CLI and from other Go programs.
+package scanner
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/time/rate"
+)
+
+// Defaults used when the corresponding Scanner field is left zero
+const (
+	DefaultWorkers      = 100
+	DefaultTimeout      = 5 * time.Second
+	DefaultRetries      = 3
+	DefaultRetryBackoff = time.Second
+
+	checkpointInterval = 5 * time.Second // How often StateFile is flushed
+)
+
+// Scanner scans every combination of Targets, Ports and Protocols.
+// Zero values fall back to sensible defaults, so only Targets and Ports
+// are required.
+type Scanner struct {
+	Targets     []string      // Hostnames, IPs or CIDR blocks
+	TargetsFile string        // File of extra targets, one per line; streamed, never loaded whole
+	Ports       []int         // Ports to scan on each target
+	Protocols   []string      // "tcp" and/or "udp", defaults to tcp
+	Workers     int           // Number of concurrent workers
+	Timeout     time.Duration // Dial timeout for each attempt; banner reads use BannerTimeout
+	Rate        int           // Max connection attempts per second, 0 for unlimited
+	MaxOpen     int           // Max sockets open at once, defaults to DefaultMaxOpen() and capped by the fd limit
+	SourceIP    string        // Local address to send from; must be assigned to this machine
+
+	// PortsByProto replaces Ports for the protocols it has an entry for, so
+	// TCP and UDP can each get their own port list, say the top TCP ports
+	// but only DNS, NTP and SNMP over UDP. Every key must be in Protocols.
+	PortsByProto map[string][]int
+
+	// EndpointsFile lists host:port pairs, one per line, to scan as given
+	// instead of every combination of Targets and Ports; the port may also
+	// be a range or service name. When set, Targets, TargetsFile, Ports
+	// and Discover are not used. Streamed, like TargetsFile.
+	EndpointsFile string
+
+	// Proxy routes every TCP connection through a SOCKS5 proxy, given as
+	// socks5://[user:pass@]host:port. UDP can't be scanned through it.
+	Proxy string
+
+	// HTTPProxy routes every TCP connection through an HTTP proxy with
+	// CONNECT, given as http://[user:pass@]host:port; credentials are sent
+	// with Basic auth. Ports the proxy won't tunnel to are reported as
+	// filtered with ReasonProxyRefused. Can't be combined with Proxy, and
+	// UDP can't be scanned through it.
+	HTTPProxy string
+
+	// Retries is the number of TCP dial attempts per port. Only timeouts and
+	// transient errors are retried; a refused connection is final.
+	Retries int
+	// RetryBackoff is the wait before the second attempt, doubling after that
+	RetryBackoff time.Duration
+	// RetryJitter randomizes each backoff by up to this fraction either way
+	// (0.5 waits between half and one and a half times as long), so workers
+	// don't all retry at once. 0 disables it; at most 1.
+	RetryJitter float64
+	// RetryOnlyFiltered retries only attempts that timed out, the ones
+	// that may mean a filtered port; any other failure, such as a reset
+	// or an unreachable host, is as final as a refusal
+	RetryOnlyFiltered bool
+	// PortBudget bounds the time spent on a TCP port across all its
+	// attempts and the backoffs between them, counted from the first
+	// attempt: each attempt gets at most what is left, and no retry is
+	// made once the backoff would use it up, so a port that keeps timing
+	// out costs PortBudget rather than Retries times Timeout. With
+	// ProbeCount, each probe gets its own budget. 0 for no budget.
+	PortBudget time.Duration
+
+	// ProbeCount scans every port this many times instead of once, to see
+	// through packet loss: a port is open if any attempt found it open,
+	// and ScanResult.Hits says how many did, out of ScanResult.Probes.
+	// Unlike Retries, which stop at the first answer, every attempt is
+	// made. 0 and 1 scan once.
+	ProbeCount int
+
+	// MaxResults stops the whole scan once this many open ports have been
+	// found, calling off every queued and in-flight task, for when any
+	// few open services will do; open results found after that are
+	// dropped, so there are exactly this many. Stats.MaxResultsReached
+	// says whether it happened. 0 for no limit; can't be combined with
+	// StateFile.
+	MaxResults int
+
+	IncludeNetworkBroadcast bool // Scan network/broadcast addresses of IPv4 CIDRs
+	// StopOnFirstOpen stops scanning a host once one of its ports is
+	// found open, calling off its queued and in-flight tasks, for quick
+	// "is it serving anything" sweeps. Tasks called off are counted as
+	// completed and in Stats.Skipped.
+	StopOnFirstOpen bool
+
+	// AllowSpecial scans loopback, link-local and multicast addresses that
+	// fall inside a CIDR target; by default they are skipped, with a
+	// warning, and counted as excluded. Single hosts, and blocks that lie
+	// entirely within such a range, are always scanned.
+	AllowSpecial bool
+	ExcludeHosts []string // IPs or CIDR blocks removed from the targets
+	HTTPProbe    bool     // Send an HTTP request to any port that stays silent
+
+	// Probes maps ports to payloads sent before reading the reply, for
+	// services that stay silent until asked. They replace the built-in UDP
+	// payloads and the HTTP probe on those ports.
+	Probes map[int][]byte
+
+	// ProbeScripts maps ports to steps run in order over one connection:
+	// each step's payload is sent and the reply read and appended to the
+	// banner. An empty step only reads, e.g. to catch a greeting first.
+	// Scripts take precedence over Probes on the same port.
+	ProbeScripts map[int][][]byte
+
+	// BannerBytes is the most read for a banner (capped at MaxBannerBytes)
+	// and BannerTimeout how long to wait for one; reading stops early once
+	// the service goes quiet. BannerFull keeps reading until EOF or the
+	// timeout, up to MaxBannerBytes.
+	BannerBytes   int
+	BannerTimeout time.Duration
+	BannerFull    bool
+	NoService     bool // Skip the port-to-service lookup
+
+	// BannerHex hex-encodes banners that aren't printable text, setting
+	// ScanResult.BannerEncoding, so binary protocols keep their exact
+	// bytes through JSON and text output. Text banners are left as is.
+	BannerHex bool
+
+	// NoBanner closes TCP connections as soon as they are established,
+	// skipping banner reads, TLS and probes, for scans that only need to
+	// know which ports are open. Banner stays empty.
+	NoBanner bool
+
+	// Discover probes every host first and only port-scans the ones that
+	// answer on one of DiscoveryPorts (DefaultDiscoveryPorts if empty)
+	Discover       bool
+	DiscoveryPorts []int
+
+	// DiscoverICMP makes Discover ping hosts with ICMP echo instead of TCP
+	// connects. It needs raw sockets (root or CAP_NET_RAW); without them,
+	// or for a family whose socket can't be opened, discovery warns and
+	// falls back to the TCP ping. Not available through a proxy.
+	DiscoverICMP bool
+
+	// SYN scans TCP ports half-open: a raw SYN is sent and the answer read
+	// off a raw socket without completing the handshake, which is faster
+	// and leaves no connection in the target's logs. It needs root or
+	// CAP_NET_RAW; without them the scan warns and falls back to connect
+	// scanning. Only IPv4 targets are SYN-scanned, and open ports get no
+	// banner or probe since no connection is made. Not available through
+	// a proxy.
+	SYN bool
+
+	// ResolveAll scans every address a target hostname resolves to instead
+	// of only the first. Names are resolved once, up front, unless scanning
+	// through Proxy or HTTPProxy, which resolve them themselves.
+	ResolveAll bool
+
+	// IPVersion restricts the scan to IPv4 (4) or IPv6 (6) addresses, both
+	// for hostnames and for IP and CIDR targets; 0 scans both families
+	IPVersion int
+
+	// HostConcurrency, when set, scans up to this many hosts at once, each
+	// with its own pool of Workers, instead of sharing one pool of Workers
+	// across all hosts; a slow host then only holds up its own pool.
+	// MaxOpen still bounds the sockets of all pools together. Only host
+	// order is possible, so it can't be combined with ScanOrder or
+	// Randomize.
+	HostConcurrency int
+
+	// ScanOrder is OrderHost (the default when empty), OrderPort or
+	// OrderInterleave. Host order finishes each host before starting the
+	// next, so one slow, filtered host holds up everything after it; the
+	// other two spread progress and load across hosts. Port order walks
+	// the targets once per port. Neither can be combined with Randomize,
+	// and port order can't be combined with StopOnFirstOpen or
+	// EndpointsFile.
+	ScanOrder string
+
+	// MaxPortsPerHost caps the port and protocol pairs scanned on each
+	// host, dropping the rest of a host's tasks once it reaches the cap,
+	// as a guardrail for exploratory scans of huge port ranges. With
+	// Randomize the ports kept are a random sample. CappedHosts reports
+	// the hosts that hit it. 0 scans every port; can't be combined with
+	// port scan order or EndpointsFile.
+	MaxPortsPerHost int
+
+	// PriorityPorts are scanned on every host before any other port, in
+	// the order given, for early results on the ports that matter most;
+	// the rest follow in the usual order. Ports that aren't scanned are
+	// ignored with a warning. Can't be combined with Randomize,
+	// StopOnFirstOpen, MaxPortsPerHost, HostConcurrency or EndpointsFile.
+	PriorityPorts []int
+
+	// Randomize shuffles the task order; Seed makes a given order reproducible
+	Randomize bool
+	Seed      int64
+
+	// StateFile checkpoints finished tasks and their results so a scan that
+	// is interrupted can be resumed by running it again with the same file
+	// and settings. The file is removed once the scan completes.
+	StateFile string
+
+	// Logger receives diagnostics such as failed attempts and retries at
+	// debug level; nil discards them
+	Logger *slog.Logger
+
+	// Thresholds for SuspectHosts; zero uses the Default* value and a
+	// negative one turns that check off. SuspectOpenRatio is the fraction
+	// of a host's probed ports, at least SuspectMinPorts of them, that
+	// may be open; SuspectLatencyFactor how many times slower than the
+	// host's fastest a connect may get; SuspectSilentRun how many
+	// answers followed by as many timeouts mean the host went silent;
+	// SuspectSameBanner how many open ports may share one banner.
+	SuspectOpenRatio     float64
+	SuspectMinPorts      int
+	SuspectLatencyFactor float64
+	SuspectSilentRun     int
+	SuspectSameBanner    int
+
+	// Baseline lists the ports expected to be open. When set, every open
+	// result is marked BaselineExpected or BaselineNew in
+	// ScanResult.Baseline, and BaselineMissing reports the expected ports
+	// that weren't found open.
+	Baseline *Baseline
+
+	// Filter decides which results Scan returns and ScanStream sends; nil
+	// keeps them all
+	Filter func(ScanResult) bool
+
+	// OnResult is called for every result, including ones Filter drops, as
+	// soon as it is collected, before Scan returns it or ScanStream sends
+	// it. Calls come from a single goroutine, one at a time, so the
+	// callback needs no locking of its own. Workers wait while it runs, so
+	// slow follow-up work such as another probe belongs on a goroutine the
+	// callback starts.
+	OnResult func(ScanResult)
+
+	dialer        net.Dialer
+	udpDialer     net.Dialer          // dialer with a UDP LocalAddr when SourceIP is set
+	log           *slog.Logger        // Effective Logger
+	retries       int                 // Effective Retries
+	backoff       time.Duration       // Effective RetryBackoff
+	bannerBytes   int                 // Effective BannerBytes
+	bannerTimeout time.Duration       // Effective BannerTimeout
+	limiter       *rate.Limiter       // Shared by all workers, nil when unlimited
+	slots         chan struct{}       // Semaphore of open sockets, sized by MaxOpen
+	proxy         proxy.ContextDialer // SOCKS5 or HTTP CONNECT dialer wrapping dialer, nil when direct
+	state         *checkpoint         // Open StateFile, nil when not resuming
+	completed     atomic.Int64        // Tasks finished so far
+	total         atomic.Int64        // Tasks in the current scan
+	hostsUp       atomic.Int64        // Hosts that answered discovery
+	hostsDown     atomic.Int64        // Hosts skipped because discovery got no answer
+	live          []LiveHost          // Hosts that answered discovery, with their RTT
+	syn           *synScanner         // Raw socket for SYN, nil for connect scans
+
+	targetOpts    targetOptions           // How target entries expand, from the fields above
+	ports         []int                   // Ports without duplicates, for every protocol together
+	protoPorts    map[string]map[int]bool // Ports each protocol scans when PortsByProto is set, else nil
+	priority      int                     // PriorityPorts at the front of ports
+	hostsExcluded atomic.Int64            // Hosts dropped by ExcludeHosts
+
+	duplicateHosts atomic.Int64 // Target entries dropped as repeats
+	duplicatePorts atomic.Int64 // Ports dropped as repeats
+
+	resolved    map[string][]string // Addresses to scan for each target hostname
+	resolveErrs []*ResolveError     // Failed lookups, in target order
+	unresolved  atomic.Int64        // Target hostnames that failed to resolve
+	skipped     atomic.Int64        // Tasks called off by StopOnFirstOpen
+	fdWarned    atomic.Bool         // Already warned about running out of file descriptors
+	proxyWarned atomic.Bool         // Already warned about the HTTP proxy refusing a CONNECT
+
+	patterns     map[hostKey]*hostPattern // Per-host result patterns for SuspectHosts and BannerCounts
+	patternOrder []hostKey                // Hosts in patterns, in the order first seen
+	baselineSeen map[baselineEntry]bool   // Baseline entries found open
+
+	baselineExpected, baselineNew int // Open results in and not in Baseline
+
+	// Dial accounting, reported by Stats and HostDialStats
+	dialAttempts, dialFailures atomic.Int64
+	dialTimeouts, dialRetries  atomic.Int64
+	dialsMu                    sync.Mutex
+	hostDials                  map[string]*HostDials // By address
+
+	cappedMu sync.Mutex
+	capped   []CappedHost // Hosts that reached MaxPortsPerHost
+
+	// Per-state result counts and timing, reported by Stats
+	open, closed, filtered, openFiltered atomic.Int64
+	started, finished                    atomic.Int64 // Unix nanoseconds, 0 if unset
+	interrupted                          atomic.Bool
+	timedOut                             atomic.Bool // Interrupted by ctx's deadline
+	maxResultsReached                    atomic.Bool // Stopped by MaxResults
+}
+
+// scanTask is a single unit of work sent to the workers
+type scanTask struct {
+	Proto string // "tcp" or "udp"
+	Addr  string // host:port
+	Name  string // Hostname the host in Addr was resolved from, if any
+
+	host *hostRun // Shared by the host's tasks with StopOnFirstOpen, else nil
+}
+
+// hostRun lets the tasks of one host be called off together once one of
+// them finds an open port. pending counts the tasks still queued or
+// running, plus one held by the feeder while it is sending them; the
+// context is released when it drops to zero.
+type hostRun struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending atomic.Int64
+}
+
+func newHostRun(ctx context.Context) *hostRun {
+	h := &hostRun{}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	h.pending.Store(1)
+	return h
+}
+
+// Mark one task, or the feeder, as done with the host
+func (h *hostRun) release() {
+	if h.pending.Add(-1) == 0 {
+		h.cancel()
+	}
+}
+
+// ResolveError reports a target hostname that couldn't be resolved. Its
+// ports are not scanned, so it never shows up as a run of closed ports.
+type ResolveError struct {
+	Host string
+	Err  error
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("cannot resolve target %s: %v", e.Host, e.Err)
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// ResolveErrors returns the target hostnames of the last scan or plan
+// that failed to resolve, one per name, in target order. Call it once
+// Scan or Plan has returned.
+func (s *Scanner) ResolveErrors() []*ResolveError {
+	return s.resolveErrs
+}
+
+// Progress reports how many tasks have finished out of the total. It is
+// safe to call from any goroutine while Scan is running.
+func (s *Scanner) Progress() (completed, total int64) {
+	return s.completed.Load(), s.total.Load()
+}
+
+// HostsDiscovered reports how many hosts answered discovery and how many
+// were skipped as down. Both are zero unless Discover is set.
+func (s *Scanner) HostsDiscovered() (up, down int64) {
+	return s.hostsUp.Load(), s.hostsDown.Load()
+}
+
+// Scan runs the scan until every task is done or ctx is cancelled, and
+// returns the results collected so far. Cancellation is not an error.
+func (s *Scanner) Scan(ctx context.Context) ([]ScanResult, error) {
+	results := []ScanResult{}
+	err := s.stream(ctx, func(r ScanResult) {
+		if s.Filter == nil || s.Filter(r) {
+			results = append(results, r)
+		}
+	})
+	if err != nil && len(results) == 0 {
+		return nil, err
+	}
+	return results, err
+}
+
+// ScanStream runs the scan in the background and sends each result that
+// passes Filter as soon as it is collected, for callers that want to
+// process results incrementally without holding them all or writing a
+// callback. The results channel is closed when the scan is done or ctx
+// is cancelled; then the error channel delivers the error Scan would
+// have returned, if any, and is closed. Results are not buffered beyond
+// a small queue, so a slow reader slows the scan down; after
+// cancellation, results not yet sent are dropped. OnResult is still
+// called for every result.
+func (s *Scanner) ScanStream(ctx context.Context) (<-chan ScanResult, <-chan error) {
+	results := make(chan ScanResult, 64)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		err := s.stream(ctx, func(r ScanResult) {
+			if s.Filter != nil && !s.Filter(r) {
+				return
+			}
+			select {
+			case results <- r:
+			case <-ctx.Done():
+			}
+		})
+		close(results)
+		if err != nil {
+			errc <- err
+		}
+	}()
+	return results, errc
+}
+
+// Run the scan, handing every collected result to OnResult and then to
+// emit, one at a time from a single goroutine
+func (s *Scanner) stream(ctx context.Context, emit func(ScanResult)) error {
+	s.resetStats()
+	defer func() {
+		s.interrupted.Store(ctx.Err() != nil)
+		s.timedOut.Store(errors.Is(ctx.Err(), context.DeadlineExceeded))
+		s.finished.Store(time.Now().UnixNano())
+	}()
+	setup, err := s.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	specs, fromFile, protos, workers, hostCount := setup.specs, setup.fromFile, setup.protos, setup.workers, setup.hosts
+
+	if s.Discover {
+		// Drop dead hosts before generating any port tasks
+		if specs, err = s.discover(ctx, specs, workers); err != nil {
+			return err
+		}
+		fromFile = false // Live hosts from the file are in specs now
+		hostCount = len(specs)
+	}
+	setup.hosts = hostCount
+	s.total.Store(setup.tasks())
+
+	s.syn = nil
+	if s.SYN {
+		var source net.IP
+		if s.dialer.LocalAddr != nil {
+			source = s.dialer.LocalAddr.(*net.TCPAddr).IP
+		}
+		if s.syn, err = newSynScanner(source); err != nil {
+			s.log.Warn("SYN scan needs root or CAP_NET_RAW, falling back to connect scan", "err", err)
+			s.syn = nil
+		} else {
+			defer s.syn.close()
+		}
+	}
+
+	s.state = nil
+	if s.StateFile != "" {
+		if s.state, err = openCheckpoint(s.StateFile, s.fingerprint(protos)); err != nil {
+			return err
+		}
+		s.completed.Store(int64(len(s.state.done)))
+		if n := len(s.state.done); n > 0 {
+			s.log.Info("resuming scan", "state_file", s.StateFile, "done", n)
+		}
+	}
+
+	// Workers run on their own context so MaxResults can call them off
+	// without the scan counting as interrupted
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	s.capped = nil // Before the feeder can cap a host
+
+	var wg sync.WaitGroup
+	taskChan := make(chan scanTask, 1000)    // Queue of scan tasks
+	resultChan := make(chan ScanResult, 256) // Small buffer, drained by the collector as results arrive
+	fed := make(chan struct{})               // Closed when the feeder returns, so a called-off scan doesn't leave it running
+
+	if s.HostConcurrency > 0 {
+		// A small pool of Workers per host, HostConcurrency hosts at once
+		hostChan := make(chan func() (scanTask, bool))
+		for i := 0; i < s.HostConcurrency; i++ {
+			wg.Add(1)
+			go s.hostWorker(scanCtx, &wg, hostChan, workers, resultChan)
+		}
+		go func() {
+			defer close(fed)
+			defer close(hostChan)
+			s.eachHost(scanCtx, specs, fromFile, s.ports, protos, func(next func() (scanTask, bool)) bool {
+				select {
+				case hostChan <- next:
+					return true
+				case <-scanCtx.Done():
+					return false
+				}
+			})
+		}()
+	} else {
+		// Start worker goroutines
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go s.worker(scanCtx, &wg, taskChan, resultChan)
+		}
+
+		// Feed tasks into the task channel until done or cancelled
+		go func() {
+			defer close(fed)
+			s.feed(scanCtx, specs, fromFile, protos, taskChan)
+		}()
+	}
+
+	// Collect results as they arrive, concurrently with the workers
+	s.patterns, s.patternOrder = map[hostKey]*hostPattern{}, nil
+	s.baselineSeen, s.baselineExpected, s.baselineNew = map[baselineEntry]bool{}, 0, 0
+	collected := make(chan struct{})
+	found := 0 // Open results, for MaxResults
+	collect := func(r ScanResult) {
+		if r.State == StateOpen && s.MaxResults > 0 {
+			if found == s.MaxResults {
+				return // In flight when the scan was called off
+			}
+			if found++; found == s.MaxResults {
+				s.log.Info("max results reached, stopping the scan", "open", found)
+				s.maxResultsReached.Store(true)
+				stop()
+			}
+		}
+		s.countState(r.State)
+		if !s.NoService {
+			r.Service = LookupService(r.Port, r.Proto)
+		}
+		if r.Banner != "" && r.BannerEncoding == "" {
+			r.DetectedProtocol = classifyBanner(r.Banner)
+			if s.BannerHex && !isPrintable(r.Banner) {
+				r.Banner, r.BannerEncoding = hex.EncodeToString([]byte(r.Banner)), BannerEncodingHex
+			}
+		}
+		s.checkBaseline(&r)
+		s.trackPattern(r)
+		if s.OnResult != nil {
+			s.OnResult(r)
+		}
+		emit(r)
+	}
+	go func() {
+		defer close(collected)
+		if s.state != nil {
+			for _, r := range s.state.restored { // Found by earlier runs
+				collect(r)
+			}
+		}
+		for r := range resultChan {
+			collect(r)
+		}
+	}()
+
+	// Checkpoint every few seconds until the workers are done
+	flushed := make(chan struct{})
+	if s.state != nil {
+		go func() {
+			ticker := time.NewTicker(checkpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					s.state.flush()
+				case <-flushed:
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()         // Wait for all workers to finish
+	<-fed             // And for the feeder, which a called-off scan may have left mid-send
+	close(resultChan) // Close result channel after workers are done
+	close(flushed)
+	<-collected // Wait for the collector to drain it
+	if s.state != nil {
+		// A finished scan has nothing left to resume
+		if err := s.state.close(scanCtx.Err() == nil); err != nil {
+			return fmt.Errorf("state file: %v", err)
+		}
+	}
+	return nil
+}
+
+// scanSetup is what prepare works out before any task is generated
+type scanSetup struct {
+	specs    []targetSpec // Inline targets, without duplicates
+	fromFile bool         // TargetsFile still has to be streamed
+	protos   []string
+	pairs    int // Port and protocol pairs scanned on each host
+	workers  int
+	hosts    int // Hosts to scan after exclusions and resolution
+	// Host and port pairs from EndpointsFile, after exclusions and resolution
+	endpoints int
+}
+
+// Number of tasks in the scan
+func (setup scanSetup) tasks() int64 {
+	return int64(setup.hosts)*int64(setup.pairs) + int64(setup.endpoints)*int64(len(setup.protos))
+}
+
+// Validate the settings, fill in defaults and count the hosts to scan,
+// resolving hostnames on the way. Nothing is dialed. Called by Scan and
+// Plan after resetStats.
+func (s *Scanner) prepare(ctx context.Context) (setup scanSetup, err error) {
+	exclude, err := parseExcludes(s.ExcludeHosts)
+	if err != nil {
+		return setup, err
+	}
+	if s.IPVersion != 0 && s.IPVersion != 4 && s.IPVersion != 6 {
+		return setup, fmt.Errorf("invalid IP version %d: want 4, 6 or 0 for both", s.IPVersion)
+	}
+	s.targetOpts = targetOptions{keepEdges: s.IncludeNetworkBroadcast, exclude: exclude, family: s.IPVersion, allowSpecial: s.AllowSpecial}
+	targets, portList := s.Targets, s.Ports
+	if s.EndpointsFile != "" {
+		if s.Discover {
+			return setup, fmt.Errorf("discovery can't be combined with an endpoints file")
+		}
+		targets, portList = nil, nil
+	}
+	specs, dupHosts, err := parseTargets(targets, s.targetOpts)
+	if err != nil {
+		return setup, err
+	}
+	for _, p := range portList {
+		if p < 1 || p > 65535 {
+			return setup, fmt.Errorf("invalid port %d: must be between 1 and 65535", p)
+		}
+	}
+	var dupPorts int
+	s.ports, dupPorts = dedupePorts(portList)
+	protos := s.Protocols
+	if len(protos) == 0 {
+		protos = []string{"tcp"}
+	}
+	for _, p := range protos {
+		if p != "tcp" && p != "udp" {
+			return setup, fmt.Errorf("unsupported protocol %q", p)
+		}
+	}
+	pairs := len(s.ports) * len(protos)
+	s.protoPorts = nil
+	if len(s.PortsByProto) > 0 && s.EndpointsFile == "" {
+		if pairs, dupPorts, err = s.splitPorts(protos); err != nil {
+			return setup, err
+		}
+	}
+	if s.MaxPortsPerHost > 0 {
+		pairs = min(pairs, s.MaxPortsPerHost)
+	}
+	workers := s.Workers
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	s.dialer = net.Dialer{Timeout: s.Timeout}
+	if s.dialer.Timeout <= 0 {
+		s.dialer.Timeout = DefaultTimeout
+	}
+	s.udpDialer = s.dialer
+	if s.SourceIP != "" {
+		ip, err := localIP(s.SourceIP)
+		if err != nil {
+			return setup, err
+		}
+		s.dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		s.udpDialer.LocalAddr = &net.UDPAddr{IP: ip}
+	}
+	s.log = s.Logger
+	if s.log == nil {
+		s.log = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	s.retries = s.Retries
+	if s.retries <= 0 {
+		s.retries = DefaultRetries
+	}
+	s.backoff = s.RetryBackoff
+	if s.backoff <= 0 {
+		s.backoff = DefaultRetryBackoff
+	}
+	if s.ProbeCount < 0 {
+		return setup, fmt.Errorf("invalid probe count %d: must not be negative", s.ProbeCount)
+	}
+	if s.MaxPortsPerHost < 0 {
+		return setup, fmt.Errorf("invalid max ports per host %d: must not be negative", s.MaxPortsPerHost)
+	}
+	if s.MaxPortsPerHost > 0 && (s.ScanOrder == OrderPort || s.EndpointsFile != "") {
+		return setup, fmt.Errorf("max ports per host can't be combined with port scan order or an endpoints file")
+	}
+	if s.HostConcurrency < 0 {
+		return setup, fmt.Errorf("invalid host concurrency %d: must not be negative", s.HostConcurrency)
+	}
+	if s.HostConcurrency > 0 && (s.Randomize || s.ScanOrder != "" && s.ScanOrder != OrderHost) {
+		return setup, fmt.Errorf("host concurrency scans hosts one by one, so it can't be combined with Randomize or another scan order")
+	}
+	switch s.ScanOrder {
+	case "", OrderHost:
+	case OrderPort, OrderInterleave:
+		if s.Randomize {
+			return setup, fmt.Errorf("%s scan order can't be combined with Randomize", s.ScanOrder)
+		}
+		if s.ScanOrder == OrderPort && (s.StopOnFirstOpen || s.EndpointsFile != "") {
+			return setup, fmt.Errorf("port scan order can't be combined with StopOnFirstOpen or an endpoints file")
+		}
+	default:
+		return setup, fmt.Errorf("invalid scan order %q: must be host, port or interleave", s.ScanOrder)
+	}
+	s.priority = 0
+	if len(s.PriorityPorts) > 0 {
+		if s.Randomize || s.StopOnFirstOpen || s.MaxPortsPerHost > 0 || s.HostConcurrency > 0 || s.EndpointsFile != "" {
+			return setup, fmt.Errorf("priority ports can't be combined with Randomize, StopOnFirstOpen, MaxPortsPerHost, HostConcurrency or an endpoints file")
+		}
+		s.prioritize()
+	}
+	if s.RetryJitter < 0 || s.RetryJitter > 1 {
+		return setup, fmt.Errorf("invalid retry jitter %v: must be between 0 and 1", s.RetryJitter)
+	}
+	if s.MaxResults < 0 {
+		return setup, fmt.Errorf("invalid max results %d: must not be negative", s.MaxResults)
+	}
+	if s.MaxResults > 0 && s.StateFile != "" {
+		return setup, fmt.Errorf("max results can't be combined with a state file")
+	}
+	if s.PortBudget < 0 {
+		return setup, fmt.Errorf("invalid port budget %s: must not be negative", s.PortBudget)
+	}
+	s.bannerBytes = min(s.BannerBytes, MaxBannerBytes)
+	if s.bannerBytes <= 0 {
+		s.bannerBytes = DefaultBannerBytes
+	}
+	s.bannerTimeout = s.BannerTimeout
+	if s.bannerTimeout <= 0 {
+		s.bannerTimeout = DefaultBannerTimeout
+	}
+	if err := s.setupProxy(protos); err != nil {
+		return setup, err
+	}
+	maxOpen := s.MaxOpen
+	if maxOpen <= 0 {
+		maxOpen = DefaultMaxOpen()
+	}
+	if budget := fdBudget(); maxOpen > budget {
+		s.log.Warn("max open sockets exceeds the file descriptor limit, lowering it", "max_open", maxOpen, "fd_limit", fdLimit(), "using", budget)
+		maxOpen = budget
+	}
+	if workers > maxOpen {
+		// Each worker holds at most one socket, so the rest would only wait
+		s.log.Warn("more workers than open socket slots, capping workers", "workers", workers, "max_open", maxOpen)
+		workers = maxOpen
+	}
+	if all := workers * s.HostConcurrency; all > maxOpen {
+		s.log.Warn("workers across all concurrent hosts exceed open socket slots, some will wait", "workers", all, "max_open", maxOpen)
+	}
+	s.slots = make(chan struct{}, maxOpen)
+	s.fdWarned.Store(false)
+	s.proxyWarned.Store(false)
+	s.limiter = nil
+	if s.Rate > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(s.Rate), 1) // Burst of 1 keeps attempts evenly spaced
+	}
+
+	s.resolved = map[string][]string{}
+	s.resolveErrs = nil
+	hostCount, excluded := 0, 0
+	countSpec := func(t targetSpec) bool {
+		if len(t.skip) > 0 {
+			ranges := make([]string, len(t.skip))
+			for i, sp := range t.skip {
+				ranges[i] = sp.network.String() + " (" + sp.kind + ")"
+			}
+			s.log.Warn("skipping special addresses in target", "target", t.network.String(), "ranges", strings.Join(ranges, ", "))
+		}
+		for _, r := range s.resolve(ctx, t) {
+			hosts, skipped := r.count()
+			hostCount += hosts
+			excluded += skipped
+		}
+		return ctx.Err() == nil
+	}
+	for _, t := range specs {
+		countSpec(t)
+	}
+	fromFile := s.TargetsFile != "" && s.EndpointsFile == ""
+	if fromFile {
+		err := eachTargetInFile(s.TargetsFile, s.targetOpts, func(t targetSpec) bool {
+			if coveredBy(t, specs) {
+				dupHosts++
+				return true
+			}
+			return countSpec(t)
+		})
+		if err != nil {
+			return setup, err
+		}
+	}
+	endpoints := 0
+	if s.EndpointsFile != "" {
+		err := eachEndpointInFile(s.EndpointsFile, s.targetOpts, func(t targetSpec, ports []int) bool {
+			for _, r := range s.resolve(ctx, t) {
+				hosts, skipped := r.count()
+				endpoints += hosts * len(ports)
+				excluded += skipped
+			}
+			return ctx.Err() == nil
+		})
+		if err != nil {
+			return setup, err
+		}
+	}
+	if hostCount == 0 && endpoints == 0 && len(s.resolveErrs) > 0 {
+		return setup, s.resolveErrs[0] // Nothing to scan because of DNS
+	}
+	s.hostsExcluded.Store(int64(excluded))
+	s.duplicateHosts.Store(int64(dupHosts))
+	s.duplicatePorts.Store(int64(dupPorts))
+	return scanSetup{specs: specs, fromFile: fromFile, protos: protos, pairs: pairs, workers: workers, hosts: hostCount, endpoints: endpoints}, nil
+}
+
+// Work out each protocol's ports from PortsByProto, falling back to Ports,
+// and set ports to all of them together. Returns the number of port and
+// protocol pairs per host and the duplicate ports dropped.
+func (s *Scanner) splitPorts(protos []string) (pairs, dups int, err error) {
+	for p := range s.PortsByProto {
+		if !slices.Contains(protos, p) {
+			return 0, 0, fmt.Errorf("ports given for protocol %q, which isn't scanned", p)
+		}
+	}
+	all := []int{}
+	s.protoPorts = map[string]map[int]bool{}
+	for _, p := range protos {
+		list, ok := s.PortsByProto[p]
+		if !ok {
+			list = s.Ports
+		}
+		for _, port := range list {
+			if port < 1 || port > 65535 {
+				return 0, 0, fmt.Errorf("invalid %s port %d: must be between 1 and 65535", p, port)
+			}
+		}
+		list, n := dedupePorts(list)
+		dups += n
+		pairs += len(list)
+		set := make(map[int]bool, len(list))
+		for _, port := range list {
+			set[port] = true
+		}
+		s.protoPorts[p] = set
+		all = append(all, list...)
+	}
+	s.ports, _ = dedupePorts(all)
+	return pairs, dups, nil
+}
+
+// Move the PriorityPorts that are scanned to the front of the port list,
+// in the order given, and note how many there are
+func (s *Scanner) prioritize() {
+	scanned := make(map[int]bool, len(s.ports))
+	for _, port := range s.ports {
+		scanned[port] = true
+	}
+	first := map[int]bool{}
+	ports := make([]int, 0, len(s.ports))
+	for _, port := range s.PriorityPorts {
+		switch {
+		case !scanned[port]:
+			s.log.Warn("priority port isn't in the scan, ignoring it", "port", port)
+		case !first[port]:
+			first[port] = true
+			ports = append(ports, port)
+		}
+	}
+	s.priority = len(ports)
+	for _, port := range s.ports {
+		if !first[port] {
+			ports = append(ports, port)
+		}
+	}
+	s.ports = ports
+}
+
+// Report whether port is scanned over proto, which differs between
+// protocols only with PortsByProto
+func (s *Scanner) scans(proto string, port int) bool {
+	return s.protoPorts == nil || s.protoPorts[proto][port]
+}
+
+// Generate every (target, port, protocol) task and send it to tasks,
+// closing the channel when done or cancelled
+func (s *Scanner) feed(ctx context.Context, specs []targetSpec, fromFile bool, protos []string, tasks chan scanTask) {
+	defer close(tasks) // Close task channel after all jobs are sent
+
+	send := func(t scanTask) bool {
+		select {
+		case tasks <- t:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	ports := s.ports
+	if s.Randomize {
+		// Shuffle the cheap lists up front, then mix tasks across hosts
+		// through a bounded window so memory doesn't grow with the scan
+		rng := rand.New(rand.NewSource(s.Seed))
+		ports = append([]int(nil), ports...)
+		rng.Shuffle(len(ports), func(i, j int) { ports[i], ports[j] = ports[j], ports[i] })
+		specs = append([]targetSpec(nil), specs...)
+		rng.Shuffle(len(specs), func(i, j int) { specs[i], specs[j] = specs[j], specs[i] })
+		sh := &taskShuffler{rng: rng, out: send}
+		send = sh.push
+		defer sh.flush()
+	}
+	s.generate(ctx, specs, fromFile, ports, protos, send)
+}
+
+// Pass every task to send, in ScanOrder, until send returns false. Targets
+// come from specs and, if fromFile is set, from streaming TargetsFile;
+// EndpointsFile entries follow with just their own ports.
+func (s *Scanner) generate(ctx context.Context, specs []targetSpec, fromFile bool, ports []int, protos []string, send func(scanTask) bool) {
+	emit := func(next func() (scanTask, bool)) bool {
+		for t, ok := next(); ok; t, ok = next() {
+			if !send(t) {
+				return false
+			}
+		}
+		return true
+	}
+	if s.ScanOrder == OrderInterleave {
+		il := &interleaver{out: send}
+		emit = il.add
+		defer il.flush()
+	}
+	s.eachHost(ctx, specs, fromFile, ports, protos, emit)
+}
+
+// Hand the tasks of every host to emit, as an iterator per host, until
+// emit returns false. Port order makes one pass over the targets per
+// port, so each host comes up once per port.
+func (s *Scanner) eachHost(ctx context.Context, specs []targetSpec, fromFile bool, ports []int, protos []string, emit func(func() (scanTask, bool)) bool) {
+	// Walk the targets, handing each host's tasks for these ports to emit
+	walk := func(ports []int) func(targetSpec) bool {
+		return func(target targetSpec) bool {
+			for _, r := range s.resolve(ctx, target) {
+				r.each(func(host string) bool { return emit(s.hostTasks(ctx, r, host, ports, protos)) })
+			}
+			return ctx.Err() == nil
+		}
+	}
+	passes := [][]int{ports}
+	if n := s.priority; n > 0 && n < len(ports) {
+		passes = [][]int{ports[:n], ports[n:]} // PriorityPorts on every host first
+	}
+	if s.ScanOrder == OrderPort {
+		// Every host on one port before the next: one pass over the
+		// targets per port, so nothing per host has to be kept
+		passes = make([][]int, len(ports)) // Already in priority order
+		for i := range ports {
+			passes[i] = ports[i : i+1]
+		}
+	}
+	for _, pass := range passes {
+		each := walk(pass)
+		for _, target := range specs {
+			if !each(target) {
+				return
+			}
+		}
+		if fromFile {
+			s.eachFileTarget(specs, each) // Already validated by Scan
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+	if s.EndpointsFile != "" {
+		eachEndpointInFile(s.EndpointsFile, s.targetOpts, func(t targetSpec, ports []int) bool {
+			return walk(ports)(t)
+		})
+	}
+}
+
+// Iterate over the tasks for one host of the resolved spec r, skipping
+// those an earlier run finished
+func (s *Scanner) hostTasks(ctx context.Context, r targetSpec, host string, ports []int, protos []string) func() (scanTask, bool) {
+	var run *hostRun
+	if s.StopOnFirstOpen {
+		run = newHostRun(ctx)
+	}
+	i, taken := 0, 0
+	return func() (scanTask, bool) {
+		for ; i < len(ports)*len(protos); i++ {
+			port, p := ports[i/len(protos)], protos[i%len(protos)]
+			if !s.scans(p, port) {
+				continue
+			}
+			if s.MaxPortsPerHost > 0 && taken == s.MaxPortsPerHost {
+				dropped := 0
+				for ; i < len(ports)*len(protos); i++ {
+					if s.scans(protos[i%len(protos)], ports[i/len(protos)]) {
+						dropped++
+					}
+				}
+				s.capHost(r, host, dropped)
+				break
+			}
+			taken++ // Tasks an earlier run finished count as well
+			t := scanTask{Proto: p, Addr: net.JoinHostPort(host, strconv.Itoa(port)), Name: r.name, host: run}
+			if s.state != nil && s.state.done[t.key()] {
+				continue // Finished by an earlier run
+			}
+			i++
+			if run != nil {
+				run.pending.Add(1)
+			}
+			return t, true
+		}
+		if run != nil && i == len(ports)*len(protos) {
+			i++ // Release the feeder's hold once
+			run.release()
+		}
+		return scanTask{}, false
+	}
+}
+
+// CappedHost is a host whose tasks were cut short by MaxPortsPerHost
+type CappedHost struct {
+	Target  string
+	IP      string // Address scanned when Target is a hostname
+	Dropped int    // Port and protocol pairs left unscanned
+}
+
+// Record that host of the resolved spec r reached MaxPortsPerHost
+func (s *Scanner) capHost(r targetSpec, host string, dropped int) {
+	h := CappedHost{Target: host, Dropped: dropped}
+	if r.name != "" {
+		h.Target, h.IP = r.name, host
+	}
+	s.cappedMu.Lock()
+	s.capped = append(s.capped, h)
+	s.cappedMu.Unlock()
+}
+
+// CappedHosts returns the hosts of the last scan that reached
+// MaxPortsPerHost, in the order they did, with how many of their tasks
+// were dropped. Call it once Scan has returned.
+func (s *Scanner) CappedHosts() []CappedHost {
+	s.cappedMu.Lock()
+	defer s.cappedMu.Unlock()
+	return slices.Clone(s.capped)
+}
+
+// Build the dialer for Proxy or HTTPProxy, if one is set
+func (s *Scanner) setupProxy(protos []string) error {
+	s.proxy = nil
+	if s.Proxy == "" && s.HTTPProxy == "" {
+		return nil
+	}
+	if s.Proxy != "" && s.HTTPProxy != "" {
+		return fmt.Errorf("a SOCKS5 proxy and an HTTP proxy can't be combined")
+	}
+	if s.Discover && s.DiscoverICMP {
+		return fmt.Errorf("ICMP discovery can't be sent through a proxy")
+	}
+	if s.SYN {
+		return fmt.Errorf("SYN scanning can't be done through a proxy")
+	}
+	for _, p := range protos {
+		if p == "udp" {
+			return fmt.Errorf("UDP scanning is not supported through a proxy")
+		}
+	}
+	if s.HTTPProxy != "" {
+		p, err := newHTTPProxy(s.HTTPProxy, &s.dialer)
+		if err != nil {
+			return err
+		}
+		s.proxy = p
+		return nil
+	}
+	u, err := url.Parse(s.Proxy)
+	if err != nil || u.Scheme != "socks5" || u.Host == "" {
+		return fmt.Errorf("invalid proxy %q: want socks5://[user:pass@]host:port", s.Proxy)
+	}
+	d, err := proxy.FromURL(u, &s.dialer)
+	if err != nil {
+		return fmt.Errorf("invalid proxy %q: %v", s.Proxy, err)
+	}
+	s.proxy = d.(proxy.ContextDialer) // The SOCKS5 dialer supports contexts
+	return nil
+}
+
+// Stream TargetsFile, skipping entries that one of the inline specs already
+// covers. Repeats within the file itself are kept so it never has to be
+// held in memory.
+func (s *Scanner) eachFileTarget(inline []targetSpec, fn func(targetSpec) bool) error {
+	return eachTargetInFile(s.TargetsFile, s.targetOpts, func(t targetSpec) bool {
+		return coveredBy(t, inline) || fn(t)
+	})
+}
+
+// Resolve a hostname spec into one spec per address to scan, named after
+// the hostname. With IPVersion set, addresses of the other family are
+// dropped before picking the first. Lookups are cached, so a name is
+// resolved once however many passes are made over the targets. IPs,
+// blocks and anything sent through the proxy come back unchanged; a
+// failed lookup is logged and yields nothing. Only called from one
+// goroutine at a time.
+func (s *Scanner) resolve(ctx context.Context, t targetSpec) []targetSpec {
+	if t.network != nil || t.name != "" || s.proxy != nil || net.ParseIP(t.host) != nil {
+		return []targetSpec{t}
+	}
+	addrs, ok := s.resolved[t.host]
+	if !ok {
+		var err error
+		addrs, err = net.DefaultResolver.LookupHost(ctx, t.host)
+		if err != nil && ctx.Err() != nil {
+			return nil // Not the name's fault, so don't remember it
+		}
+		if f := s.targetOpts.family; err == nil && f != 0 {
+			addrs = slices.DeleteFunc(addrs, func(a string) bool { return ipFamily(net.ParseIP(a)) != f })
+			if len(addrs) == 0 {
+				err = fmt.Errorf("%s has no IPv%d address", t.host, f)
+			}
+		}
+		if err != nil {
+			s.log.Warn("cannot resolve target", "host", t.host, "err", err)
+			s.unresolved.Add(1)
+			s.resolveErrs = append(s.resolveErrs, &ResolveError{Host: t.host, Err: err})
+		}
+		if !s.ResolveAll && len(addrs) > 1 {
+			addrs = addrs[:1]
+		}
+		s.resolved[t.host] = addrs
+	}
+	specs := make([]targetSpec, len(addrs))
+	for i, addr := range addrs {
+		specs[i] = targetSpec{host: normalizeHost(addr), name: t.host, opts: t.opts}
+	}
+	return specs
+}
+
+// Dial addr, first waiting for an open-connection slot and then for the
+// shared rate limiter so both limits hold no matter how many workers are
+// running. The slot is held until the returned connection is closed.
+func (s *Scanner) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return s.dialTimeout(ctx, network, addr, s.dialer.Timeout)
+}
+
+// dial with the connection attempt itself bounded by timeout instead of
+// Timeout; waiting for a slot and the rate limiter doesn't count
+func (s *Scanner) dialTimeout(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	if !s.acquireSlot(ctx) {
+		return nil, ctx.Err()
+	}
+	if err := s.wait(ctx); err != nil {
+		s.releaseSlot()
+		return nil, err
+	}
+	start := time.Now()
+	conn, err := s.connect(ctx, network, addr, timeout)
+	if err != nil {
+		s.releaseSlot()
+		return nil, err
+	}
+	return &slotConn{Conn: conn, release: s.releaseSlot, latency: time.Since(start)}, nil
+}
+
+// Wait for the rate limiter to allow another attempt. Not limiter.Wait: it
+// fails at once when the wait would pass ctx's deadline, before ctx is
+// done, and the port would look filtered.
+func (s *Scanner) wait(ctx context.Context) error {
+	if s.limiter == nil {
+		return nil
+	}
+	r := s.limiter.Reserve()
+	if d := r.Delay(); d > 0 {
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			r.Cancel()
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// Open the connection itself, through the proxy when one is configured
+func (s *Scanner) connect(ctx context.Context, network, addr string, timeout time.Duration) (net.Conn, error) {
+	family := network
+	if s.IPVersion != 0 {
+		family += strconv.Itoa(s.IPVersion) // tcp4, udp6...
+	}
+	// The dialers' own timeout is only Timeout, and through a proxy only
+	// covers reaching it; this bounds the whole attempt, SOCKS or CONNECT
+	// handshake and the proxy's own connect included
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if s.proxy != nil && network == "tcp" {
+		return s.proxy.DialContext(ctx, family, addr)
+	}
+	if network == "udp" {
+		return s.udpDialer.DialContext(ctx, family, addr)
+	}
+	return s.dialer.DialContext(ctx, family, addr)
+}
+
+// Parse a source address and check that one of this machine's interfaces
+// has it, so a typo fails up front instead of on every dial
+func localIP(addr string) (net.IP, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source IP %q", addr)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("listing local addresses: %v", err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return ip, nil
+		}
+	}
+	return nil, fmt.Errorf("source IP %s is not assigned to any local interface", addr)
+}
+
+// Scan a single task, returning its result; ok is false when the task
+// produced none. With ProbeCount, the port is scanned that many times and
+// reported open if any attempt found it open, using the first open
+// result; later attempts skip the banner.
+func (s *Scanner) scan(ctx context.Context, task scanTask) (ScanResult, bool) {
+	if s.ProbeCount <= 1 {
+		return s.scanOnce(ctx, task, true)
+	}
+	var result ScanResult
+	hits := 0
+	for i := 0; i < s.ProbeCount; i++ {
+		r, ok := s.scanOnce(ctx, task, hits == 0)
+		if !ok {
+			return r, false
+		}
+		if r.State == StateOpen {
+			if hits == 0 {
+				result = r
+			}
+			hits++
+		} else if i == 0 {
+			result = r
+		}
+	}
+	result.Probes, result.Hits = s.ProbeCount, hits
+	return result, true
+}
+
+// Scan a task once, reading a banner from an open TCP port if inspect is
+// set (and NoBanner isn't)
+func (s *Scanner) scanOnce(ctx context.Context, task scanTask, inspect bool) (result ScanResult, ok bool) {
+	// SplitHostPort understands bracketed IPv6 literals like [::1]:80
+	host, portStr, err := net.SplitHostPort(task.Addr)
+	if err != nil {
+		return result, false
+	}
+	port, _ := strconv.Atoi(portStr)
+	target, ip := host, ""
+	if task.Name != "" {
+		target, ip = task.Name, host // Report the name the user gave
+	}
+	if task.Proto == "udp" {
+		state, banner, ok := s.udpProbe(ctx, task.Addr, port)
+		if !ok || ctx.Err() != nil {
+			return result, false
+		}
+		return ScanResult{Target: target, IP: ip, Port: port, Proto: "udp", State: state, Banner: banner, Reason: udpReasons[state]}, true
+	}
+	if addr := net.ParseIP(host).To4(); s.syn != nil && addr != nil {
+		return s.synScan(ctx, addr, ScanResult{Target: target, IP: ip, Port: port, Proto: "tcp"})
+	}
+	var lastErr error
+	deadline := s.portDeadline()
+	for i := 0; i < s.retries; i++ { // Retry with exponential backoff
+		conn, err := s.dialTimeout(ctx, "tcp", task.Addr, s.attemptTimeout(deadline))
+		if err == nil || ctx.Err() == nil {
+			s.countDial(host, i > 0, err)
+		}
+		if err == nil {
+			result = ScanResult{Target: target, IP: ip, Port: port, Proto: "tcp", State: StateOpen, Reason: ReasonSynAck, LatencyMs: latencyMs(conn)}
+			if s.NoBanner || !inspect {
+				conn.Close()
+			} else {
+				s.inspectOpen(ctx, conn, task.Addr, &result)
+			}
+			return result, true
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			return result, false // An aborted dial says nothing about the port
+		}
+		var refusal *ProxyRefusedError
+		if errors.As(err, &refusal) && s.proxyWarned.CompareAndSwap(false, true) {
+			s.log.Warn("HTTP proxy refused a CONNECT, ports it won't tunnel to show as filtered", "addr", task.Addr, "status", refusal.Status)
+		}
+		if outOfFDs(err) && s.fdWarned.CompareAndSwap(false, true) {
+			s.log.Warn("out of file descriptors, ports may show as filtered: lower workers or max open sockets, or raise ulimit -n", "fd_limit", fdLimit(), "err", err)
+		}
+		s.log.Debug("dial failed", "addr", task.Addr, "attempt", i+1, "err", err)
+		if !s.retryable(err) || i == s.retries-1 {
+			break // Nothing to wait for after a definitive answer or the last attempt
+		}
+		wait := s.retryWait(i)
+		if !s.budgetLeft(deadline, wait) {
+			s.log.Debug("port budget spent", "addr", task.Addr, "attempts", i+1)
+			break
+		}
+		s.log.Debug("retrying", "addr", task.Addr, "backoff", wait)
+		select { // Exponential backoff, cut short by cancellation
+		case <-ctx.Done():
+			return result, false
+		case <-time.After(wait):
+		}
+	}
+	return ScanResult{Target: target, IP: ip, Port: port, Proto: "tcp", State: classifyDialError(lastErr), Reason: dialReason(lastErr)}, true
+}
+
+// Report whether a failed dial gets another attempt
+func (s *Scanner) retryable(err error) bool {
+	if s.RetryOnlyFiltered {
+		return timedOut(err)
+	}
+	return retryable(err)
+}
+
+// When PortBudget for a port whose first attempt starts now runs out, or
+// the zero time without one
+func (s *Scanner) portDeadline() time.Time {
+	if s.PortBudget <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(s.PortBudget)
+}
+
+// The timeout for the next attempt on a port with deadline: Timeout, or
+// less if the budget has less left
+func (s *Scanner) attemptTimeout(deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return s.dialer.Timeout
+	}
+	return min(s.dialer.Timeout, time.Until(deadline))
+}
+
+// Report whether the budget up to deadline leaves time for an attempt
+// after waiting wait
+func (s *Scanner) budgetLeft(deadline time.Time, wait time.Duration) bool {
+	return deadline.IsZero() || time.Until(deadline) > wait
+}
+
+// Backoff before the retry after attempt i (counting from 0): the base
+// doubled i times, spread by RetryJitter
+func (s *Scanner) retryWait(i int) time.Duration {
+	wait := s.backoff << i
+	if s.RetryJitter > 0 {
+		wait = time.Duration(float64(wait) * (1 + s.RetryJitter*(2*rand.Float64()-1)))
+	}
+	return wait
+}
+
+// Worker function that scans ports received from the task channel until
+// it is closed or the context is cancelled
+func (s *Scanner) worker(ctx context.Context, wg *sync.WaitGroup, tasks chan scanTask, results chan ScanResult) {
+	defer wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case task, ok := <-tasks:
+			if !ok {
+				return
+			}
+			s.run(ctx, task, results)
+		}
+	}
+}
+
+// Take hosts one at a time and scan each with its own pool of workers,
+// so a slow host only ties up its own pool. Exits when hosts is closed or
+// ctx is cancelled.
+func (s *Scanner) hostWorker(ctx context.Context, wg *sync.WaitGroup, hosts chan func() (scanTask, bool), workers int, results chan ScanResult) {
+	defer wg.Done()
+	for {
+		var next func() (scanTask, bool)
+		select {
+		case <-ctx.Done():
+			return
+		case n, ok := <-hosts:
+			if !ok {
+				return
+			}
+			next = n
+		}
+		var mu sync.Mutex // The task iterator is not safe for concurrent use
+		var pool sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			pool.Add(1)
+			go func() {
+				defer pool.Done()
+				for ctx.Err() == nil {
+					mu.Lock()
+					task, ok := next()
+					mu.Unlock()
+					if !ok {
+						return
+					}
+					s.run(ctx, task, results)
+				}
+			}()
+		}
+		pool.Wait()
+	}
+}
+
+// Scan one task from the queue and account for it
+func (s *Scanner) run(ctx context.Context, task scanTask, results chan ScanResult) {
+	taskCtx := ctx
+	if task.host != nil {
+		taskCtx = task.host.ctx
+		defer task.host.release()
+	}
+	var result ScanResult
+	ok := false
+	if taskCtx.Err() == nil {
+		result, ok = s.scan(taskCtx, task)
+	}
+	if ok {
+		results <- result
+		if task.host != nil && result.State == StateOpen {
+			task.host.cancel() // The host is serving something; that's all we wanted to know
+		}
+	}
+	if !ok && ctx.Err() != nil {
+		return // Cut short by cancellation, so not finished (and left for a resumed run)
+	}
+	if !ok && taskCtx.Err() != nil {
+		s.skipped.Add(1) // Called off by another port of the host
+	}
+	if s.state != nil {
+		if ok {
+			s.state.record(task, &result)
+		} else {
+			s.state.record(task, nil)
+		}
+	}
+	s.completed.Add(1)
+}
@@ -0,0 +1,1196 @@
+package scanner
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestScanIPv6Literal(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte("hello\r\n"))
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	s := &Scanner{Targets: []string{"::1"}, Ports: []int{port}, Timeout: time.Second}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %+v", len(results), results)
+	}
+	r := results[0]
+	if r.Target != "::1" || r.Port != port || r.State != StateOpen {
+		t.Errorf("got %s port %d %s, want ::1 port %d open", r.Target, r.Port, r.State, port)
+	}
+	if r.Banner != "hello\r\n" {
+		t.Errorf("got banner %q, want %q", r.Banner, "hello\r\n")
+	}
+}
+
+// Start a loopback listener that hands each connection to handle and
+// return its port
+func listen(t *testing.T, handle func(net.Conn)) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handle(conn)
+		}
+	}()
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// Find a loopback port with nothing listening, so connecting is refused
+func refusedPort(t *testing.T) int {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	return port
+}
+
+func TestScanStates(t *testing.T) {
+	banner := listen(t, func(c net.Conn) {
+		c.Write([]byte("SSH-2.0-test\r\n"))
+		c.Close()
+	})
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	silent := listen(t, func(c net.Conn) {
+		<-done // Accept, then never say anything
+		c.Close()
+	})
+	closed := refusedPort(t)
+
+	s := &Scanner{
+		Targets:       []string{"127.0.0.1"},
+		Ports:         []int{banner, silent, closed},
+		Timeout:       time.Second,
+		BannerTimeout: 100 * time.Millisecond,
+	}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	got := map[int]ScanResult{}
+	for _, r := range results {
+		got[r.Port] = r
+	}
+	tests := []struct {
+		name     string
+		port     int
+		state    string
+		banner   string
+		detected string
+		reason   string
+	}{
+		{"banner", banner, StateOpen, "SSH-2.0-test\r\n", "ssh", ReasonSynAck},
+		{"silent", silent, StateOpen, "", "", ReasonSynAck},
+		{"refused", closed, StateClosed, "", "", ReasonConnRefused},
+	}
+	for _, tt := range tests {
+		r, ok := got[tt.port]
+		if !ok {
+			t.Errorf("%s: no result for port %d", tt.name, tt.port)
+			continue
+		}
+		if r.State != tt.state || r.Banner != tt.banner || r.DetectedProtocol != tt.detected || r.Reason != tt.reason {
+			t.Errorf("%s: got state %s banner %q detected %q reason %s, want %s %q %q %s",
+				tt.name, r.State, r.Banner, r.DetectedProtocol, r.Reason, tt.state, tt.banner, tt.detected, tt.reason)
+		}
+		if (r.State == StateOpen) != (r.LatencyMs > 0) {
+			t.Errorf("%s: got latency %vms for a %s port, want it set only when open", tt.name, r.LatencyMs, r.State)
+		}
+	}
+
+	st := s.Stats()
+	if st.Total != 3 || st.Completed != 3 || st.Open != 2 || st.Closed != 1 {
+		t.Errorf("got stats %+v, want 3 tasks done with 2 open and 1 closed", st)
+	}
+}
+
+func TestScanResolvesHostname(t *testing.T) {
+	if addrs, err := net.LookupHost("localhost"); err != nil || len(addrs) == 0 {
+		t.Skipf("localhost does not resolve: %v", err)
+	}
+	port := listen(t, func(c net.Conn) { c.Close() })
+
+	s := &Scanner{Targets: []string{"localhost"}, Ports: []int{port}, Timeout: time.Second, ResolveAll: true}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	found := false
+	for _, r := range results {
+		if r.Target != "localhost" || r.IP == "" {
+			t.Errorf("got target %q ip %q, want localhost with its address", r.Target, r.IP)
+		}
+		if r.IP == "127.0.0.1" && r.State == StateOpen {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no open result for 127.0.0.1 in %+v", results)
+	}
+}
+
+func TestScanUnresolvable(t *testing.T) {
+	s := &Scanner{Targets: []string{"nonexistent.invalid"}, Ports: []int{80}, Timeout: time.Second}
+	_, err := s.Scan(context.Background())
+	var resolveErr *ResolveError
+	if !errors.As(err, &resolveErr) || resolveErr.Host != "nonexistent.invalid" {
+		t.Fatalf("got error %v, want a ResolveError for the name", err)
+	}
+	if st := s.Stats(); st.Unresolved != 1 {
+		t.Errorf("got %d unresolved hosts, want 1", st.Unresolved)
+	}
+
+	// Alongside a good target the scan goes ahead, and the bad name is
+	// reported on its own rather than as closed ports
+	s.Targets = []string{"127.0.0.1", "nonexistent.invalid"}
+	s.Ports = []int{refusedPort(t)}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || results[0].Target != "127.0.0.1" {
+		t.Errorf("got %+v, want just the result for 127.0.0.1", results)
+	}
+	if errs := s.ResolveErrors(); len(errs) != 1 || errs[0].Host != "nonexistent.invalid" {
+		t.Errorf("got resolve errors %v, want one for nonexistent.invalid", errs)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	s := &Scanner{
+		Targets:      []string{"10.0.0.0/16", "192.0.2.7"},
+		ExcludeHosts: []string{"10.0.0.0/24"},
+		Ports:        []int{22, 80, 80},
+		Workers:      7,
+	}
+	p, err := s.Plan(context.Background(), 3)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if p.Hosts != 65534-255+1 || p.Ports != 2 || p.Tasks != int64(p.Hosts)*2 || p.Workers != 7 || p.DuplicatePorts != 1 {
+		t.Errorf("got plan %+v", p)
+	}
+	addrs := func(tasks []Task) []string {
+		out := []string{}
+		for _, t := range tasks {
+			out = append(out, t.Addr())
+		}
+		return out
+	}
+	if got, want := addrs(p.First), []string{"10.0.1.0:22", "10.0.1.0:80", "10.0.1.1:22"}; !slices.Equal(got, want) {
+		t.Errorf("got first tasks %v, want %v", got, want)
+	}
+	if got, want := addrs(p.Last), []string{"10.0.255.254:80", "192.0.2.7:22", "192.0.2.7:80"}; !slices.Equal(got, want) {
+		t.Errorf("got last tasks %v, want %v", got, want)
+	}
+}
+
+func TestPlanCapsWorkers(t *testing.T) {
+	s := &Scanner{Targets: []string{"192.0.2.1"}, Ports: []int{80}, Workers: 50, MaxOpen: 20}
+	p, err := s.Plan(context.Background(), 0)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if p.Workers != 20 || p.MaxOpen != 20 {
+		t.Errorf("got %d workers, %d max open, want both capped at 20", p.Workers, p.MaxOpen)
+	}
+}
+
+func TestPlanScanOrder(t *testing.T) {
+	tests := []struct {
+		order string
+		want  []string
+	}{
+		{OrderHost, []string{"192.0.2.1:1", "192.0.2.1:2", "192.0.2.2:1", "192.0.2.2:2"}},
+		{OrderPort, []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.1:2", "192.0.2.2:2"}},
+		{OrderInterleave, []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.1:2", "192.0.2.2:2"}},
+	}
+	for _, tt := range tests {
+		s := &Scanner{Targets: []string{"192.0.2.1", "192.0.2.2"}, Ports: []int{1, 2}, ScanOrder: tt.order}
+		p, err := s.Plan(context.Background(), 4)
+		if err != nil {
+			t.Fatalf("%s: Plan: %v", tt.order, err)
+		}
+		var got []string
+		for _, task := range p.First {
+			got = append(got, task.Addr())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.order, got, tt.want)
+		}
+	}
+	if _, err := (&Scanner{Targets: []string{"192.0.2.1"}, Ports: []int{1}, ScanOrder: "sideways"}).Plan(context.Background(), 0); err == nil {
+		t.Error("Plan accepted an unknown scan order")
+	}
+}
+
+func TestPlanPortsByProto(t *testing.T) {
+	s := &Scanner{
+		Targets:      []string{"192.0.2.1"},
+		Ports:        []int{22, 80},
+		Protocols:    []string{"tcp", "udp"},
+		PortsByProto: map[string][]int{"udp": {53, 123, 53}},
+	}
+	p, err := s.Plan(context.Background(), 10)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	var got []string
+	for _, task := range p.First {
+		got = append(got, task.Proto+"/"+task.Addr())
+	}
+	want := []string{"tcp/192.0.2.1:22", "tcp/192.0.2.1:80", "udp/192.0.2.1:53", "udp/192.0.2.1:123"}
+	if p.Tasks != 4 || p.DuplicatePorts != 1 || !slices.Equal(got, want) {
+		t.Errorf("got %d tasks %v with %d duplicates, want %v with 1", p.Tasks, got, p.DuplicatePorts, want)
+	}
+
+	s.Protocols = []string{"tcp"}
+	if _, err := s.Plan(context.Background(), 0); err == nil {
+		t.Error("Plan accepted UDP ports without UDP in Protocols")
+	}
+}
+
+func TestInterleaverWindow(t *testing.T) {
+	var got []string
+	il := &interleaver{out: func(t scanTask) bool {
+		got = append(got, t.Addr)
+		return true
+	}}
+	s := &Scanner{}
+	for i := 0; i < interleaveWindow+1; i++ {
+		host := fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+		il.add(s.hostTasks(context.Background(), targetSpec{}, host, []int{1, 2}, []string{"tcp"}))
+	}
+	il.flush()
+	if len(got) != 2*(interleaveWindow+1) {
+		t.Fatalf("got %d tasks, want %d", len(got), 2*(interleaveWindow+1))
+	}
+	if got[0] != "10.0.0.0:1" || got[1] != "10.0.0.1:1" {
+		t.Errorf("got %v first, want hosts taking turns", got[:2])
+	}
+}
+
+func TestPlanIPVersion(t *testing.T) {
+	targets := []string{"10.0.0.0/29", "2001:db8::/125", "192.0.2.1", "2001:db8::ff"}
+	tests := []struct {
+		version  int
+		hosts    int
+		excluded int64
+	}{
+		{0, 6 + 8 + 1 + 1, 0},
+		{4, 6 + 1, 8 + 1},
+		{6, 8 + 1, 6 + 1},
+	}
+	for _, tt := range tests {
+		s := &Scanner{Targets: targets, Ports: []int{80}, IPVersion: tt.version}
+		p, err := s.Plan(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("IPv%d: Plan: %v", tt.version, err)
+		}
+		if p.Hosts != tt.hosts || p.Excluded != tt.excluded {
+			t.Errorf("IPv%d: got %d hosts, %d excluded, want %d, %d", tt.version, p.Hosts, p.Excluded, tt.hosts, tt.excluded)
+		}
+	}
+	if _, err := (&Scanner{Targets: []string{"2001:db8::/64"}, Ports: []int{80}}).Plan(context.Background(), 0); err == nil {
+		t.Error("Plan accepted an IPv6 /64, want it refused as too large")
+	}
+}
+
+func TestPlanSkipsSpecial(t *testing.T) {
+	tests := []struct {
+		name     string
+		targets  []string
+		allow    bool
+		hosts    int
+		excluded int64
+		dups     int64
+	}{
+		{"link-local inside", []string{"169.254.0.0/15"}, false, 65536 - 1, 65536 - 1, 0},
+		{"allowed", []string{"169.254.0.0/15"}, true, 2*65536 - 2, 0, 0},
+		{"explicit block", []string{"127.0.0.0/30"}, false, 2, 0, 0},
+		{"single host", []string{"224.0.0.1"}, false, 1, 0, 0},
+		{"listed host in a skipped range", []string{"169.254.0.0/15", "169.254.1.1"}, false, 65536, 65536 - 1, 0},
+		{"listed host outside a skipped range", []string{"169.254.0.0/15", "169.255.1.1"}, false, 65536 - 1, 65536 - 1, 1},
+	}
+	for _, tt := range tests {
+		s := &Scanner{Targets: tt.targets, Ports: []int{80}, AllowSpecial: tt.allow}
+		p, err := s.Plan(context.Background(), 0)
+		if err != nil {
+			t.Fatalf("%s: Plan: %v", tt.name, err)
+		}
+		if p.Hosts != tt.hosts || p.Excluded != tt.excluded || p.DuplicateHosts != tt.dups {
+			t.Errorf("%s: got %d hosts, %d excluded, %d duplicates, want %d, %d, %d", tt.name, p.Hosts, p.Excluded, p.DuplicateHosts, tt.hosts, tt.excluded, tt.dups)
+		}
+	}
+}
+
+func TestScanProbeScript(t *testing.T) {
+	port := listen(t, func(c net.Conn) {
+		defer c.Close()
+		c.Write([]byte("220 hi\r\n"))
+		buf := make([]byte, 64)
+		for {
+			n, err := c.Read(buf)
+			if err != nil {
+				return
+			}
+			c.Write(append([]byte("echo "), buf[:n]...))
+		}
+	})
+
+	s := &Scanner{
+		Targets:       []string{"127.0.0.1"},
+		Ports:         []int{port},
+		Timeout:       time.Second,
+		BannerTimeout: 200 * time.Millisecond,
+		ProbeScripts:  map[int][][]byte{port: {nil, []byte("A\n"), []byte("B\n")}},
+	}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	if want := "220 hi\r\necho A\necho B\n"; results[0].Banner != want {
+		t.Errorf("got banner %q, want %q", results[0].Banner, want)
+	}
+}
+
+func TestScanEndpointsFile(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+	path := filepath.Join(t.TempDir(), "endpoints.txt")
+	content := fmt.Sprintf("# from another tool\n127.0.0.1:%d\n\n127.0.0.1:%d # gone\n", open, closed)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Scanner{EndpointsFile: path, Ports: []int{1, 2, 3}, Timeout: time.Second}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	got := map[int]string{}
+	for _, r := range results {
+		got[r.Port] = r.State
+	}
+	if want := map[int]string{open: StateOpen, closed: StateClosed}; !maps.Equal(got, want) {
+		t.Errorf("got states %v, want %v", got, want)
+	}
+	if st := s.Stats(); st.Total != 2 {
+		t.Errorf("got %d tasks, want 2: Ports should be ignored", st.Total)
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.txt")
+	os.WriteFile(bad, []byte("127.0.0.1:22\n::1:22\n"), 0o644)
+	if _, err := (&Scanner{EndpointsFile: bad}).Scan(context.Background()); err == nil || !strings.Contains(err.Error(), "bad.txt:2:") {
+		t.Errorf("got error %v, want one naming line 2", err)
+	}
+}
+
+func TestScanOnResult(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+
+	var seen []ScanResult // No lock: calls come one at a time
+	s := &Scanner{
+		Targets:  []string{"127.0.0.1"},
+		Ports:    []int{open, closed},
+		Timeout:  time.Second,
+		Filter:   func(r ScanResult) bool { return r.State == StateOpen },
+		OnResult: func(r ScanResult) { seen = append(seen, r) },
+	}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || len(seen) != 2 {
+		t.Errorf("got %d results and %d callbacks, want 1 kept by Filter and both seen by OnResult", len(results), len(seen))
+	}
+}
+
+func TestScanStream(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+
+	s := &Scanner{
+		Targets: []string{"127.0.0.1"},
+		Ports:   []int{open, closed},
+		Timeout: time.Second,
+		Filter:  func(r ScanResult) bool { return r.State == StateOpen },
+	}
+	results, errc := s.ScanStream(context.Background())
+	var got []ScanResult
+	for r := range results {
+		got = append(got, r)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("ScanStream: %v", err)
+	}
+	if len(got) != 1 || got[0].Port != open {
+		t.Errorf("got %+v, want only the open port", got)
+	}
+
+	s.Ports = []int{0}
+	results, errc = s.ScanStream(context.Background())
+	if _, ok := <-results; ok {
+		t.Error("got a result from a scan that can't start")
+	}
+	if err := <-errc; err == nil {
+		t.Error("got no error for port 0")
+	}
+}
+
+func TestScanHTTPProxy(t *testing.T) {
+	banner := listen(t, func(c net.Conn) {
+		c.Write([]byte("SSH-2.0-test\r\n"))
+		c.Close()
+	})
+	blocked := listen(t, func(c net.Conn) { c.Close() })
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("u:p"))
+	proxyPort := listen(t, func(c net.Conn) {
+		defer c.Close()
+		req, err := http.ReadRequest(bufio.NewReader(c))
+		if err != nil {
+			return
+		}
+		switch {
+		case req.Method != http.MethodConnect:
+			io.WriteString(c, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		case req.Header.Get("Proxy-Authorization") != auth:
+			io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		case req.Host != fmt.Sprintf("127.0.0.1:%d", banner):
+			io.WriteString(c, "HTTP/1.1 403 Forbidden\r\n\r\n")
+		default:
+			target, err := net.Dial("tcp", req.Host)
+			if err != nil {
+				io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+				return
+			}
+			defer target.Close()
+			io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+			io.Copy(c, target)
+		}
+	})
+
+	s := &Scanner{
+		Targets:   []string{"127.0.0.1"},
+		Ports:     []int{banner, blocked},
+		Timeout:   time.Second,
+		HTTPProxy: fmt.Sprintf("http://u:p@127.0.0.1:%d", proxyPort),
+	}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	got := map[int]ScanResult{}
+	for _, r := range results {
+		got[r.Port] = r
+	}
+	if r := got[banner]; r.State != StateOpen || r.Banner != "SSH-2.0-test\r\n" {
+		t.Errorf("tunneled port: got %s with banner %q, want open with the SSH banner", r.State, r.Banner)
+	}
+	if r := got[blocked]; r.State != StateFiltered || r.Reason != ReasonProxyRefused {
+		t.Errorf("refused port: got %s (%s), want filtered (%s)", r.State, r.Reason, ReasonProxyRefused)
+	}
+
+	s.HTTPProxy = fmt.Sprintf("http://127.0.0.1:%d", proxyPort)
+	results, _ = s.Scan(context.Background())
+	for _, r := range results {
+		if r.Reason != ReasonProxyRefused {
+			t.Errorf("without credentials: got port %d %s (%s), want refused", r.Port, r.State, r.Reason)
+		}
+	}
+}
+
+func TestScanStopOnFirstOpen(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	ports := []int{open}
+	for i := 0; i < 5; i++ {
+		ports = append(ports, refusedPort(t))
+	}
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: ports, Workers: 1, Timeout: time.Second, StopOnFirstOpen: true}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || results[0].Port != open {
+		t.Errorf("got %+v, want only the open port", results)
+	}
+	if st := s.Stats(); st.Skipped != 5 || st.Completed != st.Total {
+		t.Errorf("got %d skipped, %d of %d completed, want 5 skipped and all completed", st.Skipped, st.Completed, st.Total)
+	}
+}
+
+func TestHostHeader(t *testing.T) {
+	tests := []struct {
+		host  string
+		port  int
+		isTLS bool
+		want  string
+	}{
+		{"example.com", 80, false, "example.com"},
+		{"example.com", 8080, false, "example.com:8080"},
+		{"example.com", 443, true, "example.com"},
+		{"example.com", 443, false, "example.com:443"},
+		{"192.0.2.1", 8443, true, "192.0.2.1:8443"},
+		{"::1", 80, false, "[::1]"},
+		{"2001:db8::1", 8000, false, "[2001:db8::1]:8000"},
+	}
+	for _, tt := range tests {
+		if got := hostHeader(tt.host, tt.port, tt.isTLS); got != tt.want {
+			t.Errorf("hostHeader(%q, %d, %v) = %q, want %q", tt.host, tt.port, tt.isTLS, got, tt.want)
+		}
+	}
+}
+
+func TestScanHTTPHostIPv6(t *testing.T) {
+	ln, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	defer ln.Close()
+	hosts := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			if req, err := http.ReadRequest(bufio.NewReader(conn)); err == nil {
+				hosts <- req.Host
+				io.WriteString(conn, "HTTP/1.0 200 OK\r\n\r\n")
+			}
+			conn.Close()
+		}
+	}()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	s := &Scanner{Targets: []string{"::1"}, Ports: []int{port}, Timeout: time.Second, BannerTimeout: 200 * time.Millisecond, HTTPProbe: true}
+	if _, err := s.Scan(context.Background()); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	select {
+	case host := <-hosts:
+		if want := fmt.Sprintf("[::1]:%d", port); host != want {
+			t.Errorf("got Host %q, want %q", host, want)
+		}
+	default:
+		t.Fatal("listener got no HTTP request")
+	}
+}
+
+func TestScanHTTPInfo(t *testing.T) {
+	port := listen(t, func(c net.Conn) {
+		defer c.Close()
+		if _, err := http.ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.0 403 Forbidden\r\nServer: nginx/1.25.3\r\n\r\n<html><head><TITLE>\n  Keep &amp; out\n</TITLE></head></html>")
+	})
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{port}, Timeout: time.Second, BannerTimeout: 200 * time.Millisecond, HTTPProbe: true}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	want := HTTPInfo{Status: 403, Server: "nginx/1.25.3", Title: "Keep & out"}
+	if r := results[0]; r.HTTP == nil || *r.HTTP != want || r.HTTPServer != want.Server {
+		t.Errorf("got HTTP %+v, server %q, want %+v", r.HTTP, r.HTTPServer, want)
+	}
+
+	for page, want := range map[string]string{
+		"<title>Home</title>":                  "Home",
+		"<title lang=en>A  b</title>":          "A b",
+		"<p>no title</p>":                      "",
+		"<title>Cut off before the end of the": "",
+	} {
+		if got := htmlTitle([]byte(page)); got != want {
+			t.Errorf("htmlTitle(%q) = %q, want %q", page, got, want)
+		}
+	}
+}
+
+func TestIsPrintable(t *testing.T) {
+	for s, want := range map[string]bool{
+		"SSH-2.0-OpenSSH_9.6\r\n": true,
+		"220 café ready":          true,
+		"replaced \uFFFD char":    true,
+		"cut off caf\xc3":         true, // The read ended mid-character
+		"\x00\x10A":               false,
+		"\xff\xfe\xfd":            false,
+		"\x80\x81binary\xc3":      false,
+		"caf\xc3 mid-string":      false,
+	} {
+		if got := isPrintable(s); got != want {
+			t.Errorf("isPrintable(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+// A self-signed certificate for a loopback TLS listener
+func testCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "portscan test"},
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestScanTLSRetry(t *testing.T) {
+	cfg := &tls.Config{Certificates: []tls.Certificate{testCert(t)}}
+	var conns atomic.Int32
+	port := listen(t, func(c net.Conn) {
+		defer c.Close()
+		if conns.Add(1) == 1 {
+			c.Write([]byte{0xff, 0xfe, 'A'}) // High bytes, no control ones
+			return
+		}
+		tc := tls.Server(c, cfg)
+		if tc.Handshake() == nil {
+			tc.Write([]byte("hello over tls\r\n"))
+		}
+	})
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{port}, Timeout: time.Second}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1: %+v", len(results), results)
+	}
+	r := results[0]
+	if r.TLS == nil || r.TLS.Subject != "CN=portscan test" {
+		t.Errorf("got TLS %+v, want the listener's certificate after the binary read", r.TLS)
+	}
+	if r.Banner != "hello over tls\r\n" {
+		t.Errorf("got banner %q, want the one read over TLS", r.Banner)
+	}
+}
+
+func TestScanBannerHex(t *testing.T) {
+	binary := listen(t, func(c net.Conn) {
+		c.Write([]byte{0x00, 0xff, 0x10, 'A'})
+		c.Close()
+	})
+	highBytes := listen(t, func(c net.Conn) {
+		c.Write([]byte{0xff, 0xfe, 'A'}) // Not UTF-8, but no control bytes either
+		c.Close()
+	})
+	text := listen(t, func(c net.Conn) {
+		c.Write([]byte("220 ready\r\n"))
+		c.Close()
+	})
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{binary, highBytes, text}, Timeout: time.Second, BannerHex: true}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	got := map[int]ScanResult{}
+	for _, r := range results {
+		got[r.Port] = r
+	}
+	if r := got[binary]; r.Banner != "00ff1041" || r.BannerEncoding != BannerEncodingHex {
+		t.Errorf("binary banner: got %q encoding %q, want 00ff1041 as hex", r.Banner, r.BannerEncoding)
+	}
+	if r := got[highBytes]; r.Banner != "fffe41" || r.BannerEncoding != BannerEncodingHex {
+		t.Errorf("high-byte banner: got %q encoding %q, want fffe41 as hex", r.Banner, r.BannerEncoding)
+	}
+	if r := got[text]; r.Banner != "220 ready\r\n" || r.BannerEncoding != "" {
+		t.Errorf("text banner: got %q encoding %q, want it unchanged", r.Banner, r.BannerEncoding)
+	}
+}
+
+func TestScanHostConcurrency(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+
+	s := &Scanner{
+		Targets:         []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"},
+		Ports:           []int{open, closed},
+		Workers:         2,
+		HostConcurrency: 2,
+		Timeout:         time.Second,
+	}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 6 {
+		t.Errorf("got %d results, want one per host and port: %+v", len(results), results)
+	}
+	if st := s.Stats(); st.Completed != 6 || st.Total != 6 {
+		t.Errorf("got %d of %d tasks completed, want 6 of 6", st.Completed, st.Total)
+	}
+	s.Randomize = true
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Error("Scan accepted HostConcurrency with Randomize")
+	}
+}
+
+func TestScanDiscoverICMP(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+
+	// Without raw sockets discovery falls back to the TCP ping, which the
+	// refused port answers, so the host is up either way
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{open}, Timeout: time.Second,
+		Discover: true, DiscoverICMP: true, DiscoveryPorts: []int{refusedPort(t)}}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || results[0].Port != open {
+		t.Errorf("got %+v, want the open port", results)
+	}
+	live := s.LiveHosts()
+	if len(live) != 1 || live[0].Host != "127.0.0.1" || live[0].RTT <= 0 {
+		t.Errorf("got live hosts %+v, want 127.0.0.1 with its RTT", live)
+	}
+
+	s.Proxy = "socks5://127.0.0.1:1080"
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Error("ICMP discovery through a proxy: got no error")
+	}
+}
+
+func TestScanSYN(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+
+	// Without raw sockets this is a connect scan, with the same results
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{open, closed}, Timeout: time.Second, SYN: true}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	states := map[int]string{}
+	for _, r := range results {
+		states[r.Port] = r.State
+		if r.Port == open && r.Reason != ReasonSynAck {
+			t.Errorf("open port: got reason %q, want %q", r.Reason, ReasonSynAck)
+		}
+	}
+	if states[open] != StateOpen || states[closed] != StateClosed {
+		t.Errorf("got states %v, want %d open and %d closed", states, open, closed)
+	}
+
+	s.Proxy = "socks5://127.0.0.1:1080"
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Error("SYN scan through a proxy: got no error")
+	}
+}
+
+func TestScanNoBanner(t *testing.T) {
+	port := listen(t, func(c net.Conn) {
+		defer c.Close()
+		time.Sleep(100 * time.Millisecond)
+		c.Write([]byte("SSH-2.0-test\r\n"))
+	})
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{port}, Timeout: time.Second, NoBanner: true}
+	start := time.Now()
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || results[0].State != StateOpen || results[0].Banner != "" {
+		t.Errorf("got %+v, want the port open without a banner", results)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Errorf("scan took %s, want it not to wait for the banner", d)
+	}
+}
+
+func TestScanProbeCount(t *testing.T) {
+	open := listen(t, func(c net.Conn) {
+		defer c.Close()
+		c.Write([]byte("SSH-2.0-test\r\n"))
+	})
+	closed := refusedPort(t)
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{open, closed}, Timeout: time.Second, ProbeCount: 3}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, r := range results {
+		state, hits, banner := StateClosed, 0, ""
+		if r.Port == open {
+			state, hits, banner = StateOpen, 3, "SSH-2.0-test\r\n"
+		}
+		if r.State != state || r.Probes != 3 || r.Hits != hits || r.Banner != banner {
+			t.Errorf("port %d: got %s with %d/%d hits and banner %q, want %s with %d/3 and %q", r.Port, r.State, r.Hits, r.Probes, r.Banner, state, hits, banner)
+		}
+	}
+
+	s.ProbeCount = -1
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Error("Scan accepted a negative ProbeCount")
+	}
+}
+
+func TestDialStats(t *testing.T) {
+	open := listen(t, func(c net.Conn) { c.Close() })
+	closed := refusedPort(t)
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{open, closed}, Timeout: time.Second, NoBanner: true}
+	if _, err := s.Scan(context.Background()); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	st := s.Stats()
+	if st.DialAttempts != 2 || st.DialFailures != 1 || st.DialTimeouts != 0 || st.DialRetries != 0 {
+		t.Errorf("got %d attempts, %d failures, %d timeouts, %d retries, want 2, 1, 0, 0", st.DialAttempts, st.DialFailures, st.DialTimeouts, st.DialRetries)
+	}
+	if hosts := s.HostDialStats(); len(hosts) != 0 {
+		t.Errorf("got host dial stats %+v, want none for a refusal", hosts)
+	}
+
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	s.countDial("10.0.0.1", false, timeout)
+	s.countDial("10.0.0.1", true, nil)
+	s.countDial("10.0.0.2", false, timeout)
+	s.countDial("10.0.0.2", true, timeout)
+	hosts := s.HostDialStats()
+	if len(hosts) != 2 || hosts[0].Host != "10.0.0.2" || hosts[0].Timeouts != 2 || hosts[1].Host != "10.0.0.1" || hosts[1].Attempts != 2 || hosts[1].Retries != 1 || hosts[1].Failures != 1 {
+		t.Errorf("got host dial stats %+v, want 10.0.0.2 with 2 timeouts, then 10.0.0.1 with 1 of 2 attempts failed and 1 retry", hosts)
+	}
+}
+
+func TestScanMaxPortsPerHost(t *testing.T) {
+	ports := []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10} // Refused, or open if something listens
+	s := &Scanner{Targets: []string{"127.0.0.1", "127.0.0.2"}, Ports: ports, Timeout: time.Second, MaxPortsPerHost: 3}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 6 {
+		t.Errorf("got %d results, want 3 per host", len(results))
+	}
+	if st := s.Stats(); st.Total != 6 || st.Completed != 6 {
+		t.Errorf("got %d of %d tasks completed, want 6 of 6", st.Completed, st.Total)
+	}
+	want := []CappedHost{{Target: "127.0.0.1", Dropped: 7}, {Target: "127.0.0.2", Dropped: 7}}
+	if got := s.CappedHosts(); !slices.Equal(got, want) {
+		t.Errorf("got capped hosts %+v, want %+v", got, want)
+	}
+
+	s.ScanOrder = OrderPort
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Error("Scan accepted MaxPortsPerHost with port order")
+	}
+}
+
+func TestPriorityPorts(t *testing.T) {
+	addrs := func(tasks []Task) []string {
+		var out []string
+		for _, t := range tasks {
+			out = append(out, t.Addr())
+		}
+		return out
+	}
+	s := &Scanner{Targets: []string{"127.0.0.1", "127.0.0.2"}, Ports: []int{1, 2, 3}, PriorityPorts: []int{3, 9}}
+	p, err := s.Plan(context.Background(), 6)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want := []string{"127.0.0.1:3", "127.0.0.2:3", "127.0.0.1:1", "127.0.0.1:2", "127.0.0.2:1", "127.0.0.2:2"}
+	if got := addrs(p.First); !slices.Equal(got, want) {
+		t.Errorf("got tasks %v, want %v", got, want)
+	}
+
+	s.ScanOrder = OrderPort
+	if p, err = s.Plan(context.Background(), 6); err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	want = []string{"127.0.0.1:3", "127.0.0.2:3", "127.0.0.1:1", "127.0.0.2:1", "127.0.0.1:2", "127.0.0.2:2"}
+	if got := addrs(p.First); !slices.Equal(got, want) {
+		t.Errorf("port order: got tasks %v, want %v", got, want)
+	}
+
+	s.ScanOrder, s.Randomize = "", true
+	if _, err := s.Plan(context.Background(), 0); err == nil {
+		t.Error("Plan accepted PriorityPorts with Randomize")
+	}
+}
+
+func TestScanMaxResults(t *testing.T) {
+	var ports []int
+	for i := 0; i < 5; i++ {
+		ports = append(ports, listen(t, func(c net.Conn) { c.Close() }))
+	}
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: ports, Timeout: time.Second, NoBanner: true, Workers: 1, MaxResults: 2}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 2 {
+		t.Errorf("got %d results, want 2", len(results))
+	}
+	if st := s.Stats(); !st.MaxResultsReached || st.Interrupted || st.Open != 2 {
+		t.Errorf("got %d open, max results reached %v, interrupted %v, want 2, true, false", st.Open, st.MaxResultsReached, st.Interrupted)
+	}
+
+	s.MaxResults = 10
+	if _, err := s.Scan(context.Background()); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if st := s.Stats(); st.MaxResultsReached || st.Open != 5 {
+		t.Errorf("got %d open, max results reached %v, want 5, false", st.Open, st.MaxResultsReached)
+	}
+}
+
+func TestScanBaseline(t *testing.T) {
+	known := listen(t, func(c net.Conn) { c.Close() })
+	added := listen(t, func(c net.Conn) { c.Close() })
+
+	path := filepath.Join(t.TempDir(), "baseline.json")
+	list := fmt.Sprintf(`["127.0.0.1:%d", "127.0.0.1:%d/udp", "db1:5432"]`, known, added)
+	if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b, err := LoadBaseline(path)
+	if err != nil {
+		t.Fatalf("LoadBaseline: %v", err)
+	}
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{known, added}, Timeout: time.Second, Baseline: b}
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	got := map[int]string{}
+	for _, r := range results {
+		got[r.Port] = r.Baseline
+	}
+	if want := map[int]string{known: BaselineExpected, added: BaselineNew}; !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	want := []string{fmt.Sprintf("127.0.0.1:%d/udp", added), "db1:5432"}
+	if missing := s.BaselineMissing(); !slices.Equal(missing, want) {
+		t.Errorf("got missing %v, want %v", missing, want)
+	}
+	if expected, added := s.BaselineCounts(); expected != 1 || added != 1 {
+		t.Errorf("got %d expected and %d new, want 1 and 1", expected, added)
+	}
+
+	// An earlier scan's JSON output works as a baseline too
+	doc := `{"results": [{"target": "127.0.0.1", "port": 22, "proto": "tcp", "state": "open"}, {"target": "127.0.0.1", "port": 23, "proto": "tcp", "state": "closed"}]}`
+	if err := os.WriteFile(path, []byte(doc), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = LoadBaseline(path); err != nil {
+		t.Fatalf("LoadBaseline from scan results: %v", err)
+	}
+	if b.Len() != 1 || !b.Contains(ScanResult{Target: "127.0.0.1", Port: 22, Proto: "tcp"}) {
+		t.Errorf("from scan results: got %d entries, want only port 22", b.Len())
+	}
+
+	for _, bad := range []string{`["127.0.0.1"]`, `["127.0.0.1:0"]`, `["127.0.0.1:22/sctp"]`, `{"results": 1}`} {
+		os.WriteFile(path, []byte(bad), 0o644)
+		if _, err := LoadBaseline(path); err == nil {
+			t.Errorf("LoadBaseline(%s): got no error", bad)
+		}
+	}
+}
+
+func TestScanSlowBanner(t *testing.T) {
+	done := make(chan struct{})
+	t.Cleanup(func() { close(done) })
+	port := listen(t, func(c net.Conn) {
+		defer c.Close()
+		c.Write([]byte("220-mail.example.com "))
+		time.Sleep(50 * time.Millisecond)
+		c.Write([]byte("ESMTP\r\n"))
+		<-done // Stay connected, like a server waiting for the client
+	})
+
+	s := &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{port}, Timeout: time.Second, BannerTimeout: 5 * time.Second, NoService: true}
+	start := time.Now()
+	results, err := s.Scan(context.Background())
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(results) != 1 || results[0].Banner != "220-mail.example.com ESMTP\r\n" {
+		t.Fatalf("got %+v, want the banner from both writes", results)
+	}
+	if took := time.Since(start); took > 2*time.Second {
+		t.Errorf("took %s, want the read to stop once the service went quiet", took)
+	}
+}
+
+func TestRetryOnlyFiltered(t *testing.T) {
+	timeout := &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	reset := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNRESET)}
+	refusal := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+
+	for _, c := range []struct {
+		onlyFiltered bool
+		err          error
+		want         bool
+	}{
+		{false, timeout, true}, {false, reset, true}, {false, refusal, false},
+		{true, timeout, true}, {true, reset, false}, {true, refusal, false},
+	} {
+		s := &Scanner{RetryOnlyFiltered: c.onlyFiltered}
+		if got := s.retryable(c.err); got != c.want {
+			t.Errorf("RetryOnlyFiltered %v, %v: got retryable %v, want %v", c.onlyFiltered, c.err, got, c.want)
+		}
+	}
+}
+
+func TestPortBudget(t *testing.T) {
+	s := &Scanner{PortBudget: time.Second}
+	s.dialer.Timeout = 5 * time.Second
+	deadline := s.portDeadline()
+	if d := s.attemptTimeout(deadline); d > time.Second || d < 900*time.Millisecond {
+		t.Errorf("got attempt timeout %s, want the budget's second", d)
+	}
+	if !s.budgetLeft(deadline, 100*time.Millisecond) || s.budgetLeft(deadline, 2*time.Second) {
+		t.Errorf("want budget left for a 100ms backoff but not a 2s one")
+	}
+	s.PortBudget = 0
+	if deadline := s.portDeadline(); s.attemptTimeout(deadline) != 5*time.Second || !s.budgetLeft(deadline, time.Hour) {
+		t.Errorf("want Timeout per attempt and no limit without a budget")
+	}
+
+	s = &Scanner{Targets: []string{"127.0.0.1"}, Ports: []int{80}, PortBudget: -time.Second}
+	if _, err := s.Scan(context.Background()); err == nil {
+		t.Errorf("Scan with a negative PortBudget: got no error")
+	}
+}
+
+func TestSuspectHosts(t *testing.T) {
+	s := &Scanner{SuspectMinPorts: 4, SuspectSilentRun: 3}
+	s.patterns = map[hostKey]*hostPattern{}
+	add := func(target string, port int, state string, latency float64) {
+		s.trackPattern(ScanResult{Target: target, Port: port, Proto: "tcp", State: state, LatencyMs: latency})
+	}
+	for port := 1; port <= 5; port++ {
+		add("tarpit", port, StateOpen, 1) // Everything open
+		add("normal", port, StateClosed, 0)
+	}
+	add("normal", 22, StateOpen, 2)
+	add("slow", 22, StateOpen, 2)
+	add("slow", 80, StateOpen, 400)
+	for port := 1; port <= 3; port++ {
+		add("blocked", port, StateClosed, 0)
+	}
+	for port := 4; port <= 7; port++ {
+		add("blocked", port, StateFiltered, 0)
+	}
+	for port := 100; port < 105; port++ {
+		s.trackPattern(ScanResult{Target: "honeypot", Port: port, Proto: "tcp", State: StateOpen, Banner: "SSH-2.0-OpenSSH_5.1\r\n"})
+	}
+	s.trackPattern(ScanResult{Target: "honeypot", Port: 80, Proto: "tcp", State: StateOpen, Banner: "HTTP/1.0 200 OK\r\n"})
+
+	got := map[string]string{}
+	for _, h := range s.SuspectHosts() {
+		got[h.Target] = h.Reason
+	}
+	want := map[string]string{"tarpit": SuspectMostlyOpen, "slow": SuspectLatencySpike, "blocked": SuspectWentSilent, "honeypot": SuspectSharedBanner}
+	if !maps.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	counts := s.BannerCounts()
+	if len(counts) != 1 || counts[0] != (BannerCount{Target: "honeypot", Ports: 6, Distinct: 2}) {
+		t.Errorf("got banner counts %+v, want 6 banners, 2 distinct on honeypot", counts)
+	}
+
+	s.SuspectOpenRatio, s.SuspectSilentRun, s.SuspectSameBanner = -1, -1, -1
+	if got := s.SuspectHosts(); len(got) != 1 || got[0].Target != "slow" {
+		t.Errorf("with checks off got %+v, want only the latency spike", got)
+	}
+}
+
+func TestCollapseIPs(t *testing.T) {
+	results := []ScanResult{
+		{Target: "www", IP: "192.0.2.10", Port: 443, Proto: "tcp", State: StateOpen, Banner: "x"},
+		{Target: "www", IP: "192.0.2.10", Port: 22, Proto: "tcp", State: StateOpen},
+		{Target: "www", IP: "192.0.2.11", Port: 443, Proto: "tcp", State: StateOpen, Banner: "x"},
+		{Target: "www", IP: "192.0.2.11", Port: 22, Proto: "tcp", State: StateOpen, Banner: "odd one out"},
+		{Target: "192.0.2.12", Port: 443, Proto: "tcp", State: StateOpen, Banner: "x"},
+	}
+	got := CollapseIPs(results)
+	if len(got) != 4 {
+		t.Fatalf("got %d results, want 4: %+v", len(got), got)
+	}
+	if !slices.Equal(got[0].IPs, []string{"192.0.2.10", "192.0.2.11"}) || got[0].IP != "192.0.2.10" {
+		t.Errorf("merged result: got IP %q IPs %v, want both addresses", got[0].IP, got[0].IPs)
+	}
+	for _, r := range got[1:] {
+		if r.IPs != nil {
+			t.Errorf("%s:%d: got IPs %v, want none for an unmerged result", r.Target, r.Port, r.IPs)
+		}
+	}
+}
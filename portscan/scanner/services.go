@@ -0,0 +1,236 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Well-known IANA service names for common ports, keyed by protocol
+var serviceNames = map[string]map[int]string{
+	"tcp": {
+		7:     "echo",
+		9:     "discard",
+		13:    "daytime",
+		19:    "chargen",
+		20:    "ftp-data",
+		21:    "ftp",
+		22:    "ssh",
+		23:    "telnet",
+		25:    "smtp",
+		37:    "time",
+		43:    "whois",
+		49:    "tacacs",
+		53:    "domain",
+		70:    "gopher",
+		79:    "finger",
+		80:    "http",
+		88:    "kerberos",
+		106:   "pop3pw",
+		110:   "pop3",
+		111:   "rpcbind",
+		113:   "ident",
+		119:   "nntp",
+		135:   "msrpc",
+		139:   "netbios-ssn",
+		143:   "imap",
+		179:   "bgp",
+		389:   "ldap",
+		427:   "svrloc",
+		443:   "https",
+		444:   "snpp",
+		445:   "microsoft-ds",
+		465:   "smtps",
+		497:   "retrospect",
+		513:   "login",
+		514:   "shell",
+		515:   "printer",
+		543:   "klogin",
+		544:   "kshell",
+		548:   "afp",
+		554:   "rtsp",
+		587:   "submission",
+		631:   "ipp",
+		636:   "ldaps",
+		646:   "ldp",
+		873:   "rsync",
+		990:   "ftps",
+		993:   "imaps",
+		995:   "pop3s",
+		1025:  "nfs-or-iis",
+		1080:  "socks",
+		1433:  "ms-sql-s",
+		1521:  "oracle",
+		1723:  "pptp",
+		1883:  "mqtt",
+		2049:  "nfs",
+		2121:  "ccproxy-ftp",
+		2375:  "docker",
+		2376:  "docker-s",
+		3128:  "squid-http",
+		3306:  "mysql",
+		3389:  "ms-wbt-server",
+		3690:  "svn",
+		4369:  "epmd",
+		5000:  "upnp",
+		5060:  "sip",
+		5222:  "xmpp-client",
+		5432:  "postgresql",
+		5672:  "amqp",
+		5900:  "vnc",
+		5985:  "wsman",
+		5986:  "wsmans",
+		6000:  "x11",
+		6379:  "redis",
+		6443:  "kubernetes",
+		6667:  "irc",
+		8000:  "http-alt",
+		8008:  "http",
+		8080:  "http-proxy",
+		8443:  "https-alt",
+		8888:  "sun-answerbook",
+		9000:  "cslistener",
+		9090:  "zeus-admin",
+		9100:  "jetdirect",
+		9200:  "elasticsearch",
+		9418:  "git",
+		11211: "memcache",
+		27017: "mongod",
+	},
+	"udp": {
+		53:    "domain",
+		67:    "dhcps",
+		68:    "dhcpc",
+		69:    "tftp",
+		111:   "rpcbind",
+		123:   "ntp",
+		137:   "netbios-ns",
+		138:   "netbios-dgm",
+		161:   "snmp",
+		162:   "snmptrap",
+		500:   "isakmp",
+		514:   "syslog",
+		520:   "route",
+		623:   "asf-rmcp",
+		1194:  "openvpn",
+		1434:  "ms-sql-m",
+		1900:  "upnp",
+		4500:  "nat-t-ike",
+		5060:  "sip",
+		5353:  "zeroconf",
+		11211: "memcache",
+		51820: "wireguard",
+	},
+}
+
+// LookupService returns the well-known service name for a port, or "" if unknown
+func LookupService(port int, proto string) string {
+	return serviceNames[proto][port]
+}
+
+// Everyday names for services whose IANA name is less obvious
+var serviceAliases = map[string]string{
+	"dns":      "domain",
+	"rdp":      "ms-wbt-server",
+	"postgres": "postgresql",
+	"www":      "http",
+}
+
+// Service name to port, built from serviceNames on first use
+var (
+	servicePortsOnce sync.Once
+	servicePorts     map[string]int
+)
+
+// LookupPort returns the port for a well-known service name such as "ssh"
+// or "https", the reverse of LookupService. Names are case-insensitive;
+// when a name is listed on several ports the lowest one wins.
+func LookupPort(name string) (int, bool) {
+	servicePortsOnce.Do(buildServicePorts)
+	name = strings.ToLower(name)
+	if alias, ok := serviceAliases[name]; ok {
+		name = alias
+	}
+	port, ok := servicePorts[name]
+	return port, ok
+}
+
+func buildServicePorts() {
+	servicePorts = map[string]int{}
+	for _, ports := range serviceNames {
+		for port, svc := range ports {
+			addServicePort(svc, port)
+		}
+	}
+}
+
+func addServicePort(name string, port int) {
+	if p, ok := servicePorts[name]; !ok || port < p {
+		servicePorts[name] = port
+	}
+}
+
+// LoadServices reads an nmap-style services file, one "name port/proto"
+// entry per line with anything after it (nmap's frequency column, a "#"
+// comment) ignored, and adds it to the table behind LookupService and
+// LookupPort. Entries replace the built-in name for the same port, so
+// services on nonstandard ports get their local names; built-in names
+// stay valid for LookupPort. Malformed lines are skipped and passed to
+// warn, which may be nil; entries for protocols other than tcp and udp,
+// and nmap's "unknown" placeholders, are skipped quietly. It returns the
+// number of entries loaded. Call it before scanning: the table isn't
+// locked against concurrent lookups.
+func LoadServices(path string, warn func(error)) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	servicePortsOnce.Do(buildServicePorts)
+	loaded := 0
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		name, port, proto, err := parseServiceEntry(fields)
+		if err != nil {
+			if warn != nil {
+				warn(fmt.Errorf("%s:%d: %v", path, n, err))
+			}
+			continue
+		}
+		if name == "unknown" || (proto != "tcp" && proto != "udp") {
+			continue
+		}
+		serviceNames[proto][port] = name
+		addServicePort(name, port)
+		loaded++
+	}
+	if err := sc.Err(); err != nil {
+		return loaded, fmt.Errorf("%s: %v", path, err)
+	}
+	return loaded, nil
+}
+
+// Parse the name and port/proto fields of a services entry
+func parseServiceEntry(fields []string) (name string, port int, proto string, err error) {
+	if len(fields) < 2 {
+		return "", 0, "", fmt.Errorf("want name port/proto, got %q", strings.Join(fields, " "))
+	}
+	portStr, proto, ok := strings.Cut(fields[1], "/")
+	if !ok || proto == "" {
+		return "", 0, "", fmt.Errorf("want port/proto, got %q", fields[1])
+	}
+	port, err = strconv.Atoi(portStr)
+	if err != nil || port < 1 || port > 65535 {
+		return "", 0, "", fmt.Errorf("invalid port %q", portStr)
+	}
+	return strings.ToLower(fields[0]), port, strings.ToLower(proto), nil
+}
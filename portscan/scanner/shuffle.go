@@ -0,0 +1,40 @@
+package scanner
+
+import "math/rand"
+
+// Number of tasks held back for shuffling. Order is randomized within this
+// window instead of across the whole scan, so memory stays bounded even
+// for huge task sets.
+const shuffleWindow = 4096
+
+// taskShuffler randomizes task order on the fly: once its pool is full,
+// every new task swaps places with a random pooled one, which is emitted
+type taskShuffler struct {
+	rng  *rand.Rand
+	pool []scanTask
+	out  func(scanTask) bool
+}
+
+// Add a task, possibly emitting a random earlier one. Returns false once
+// out does, meaning the feed should stop.
+func (sh *taskShuffler) push(t scanTask) bool {
+	if len(sh.pool) < shuffleWindow {
+		sh.pool = append(sh.pool, t)
+		return true
+	}
+	i := sh.rng.Intn(len(sh.pool))
+	next := sh.pool[i]
+	sh.pool[i] = t
+	return sh.out(next)
+}
+
+// Emit whatever is still pooled, in random order
+func (sh *taskShuffler) flush() {
+	sh.rng.Shuffle(len(sh.pool), func(i, j int) { sh.pool[i], sh.pool[j] = sh.pool[j], sh.pool[i] })
+	for _, t := range sh.pool {
+		if !sh.out(t) {
+			return
+		}
+	}
+	sh.pool = nil
+}
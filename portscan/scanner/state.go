@@ -0,0 +1,141 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// checkpoint records finished tasks and their results so an interrupted
+// scan can pick up where it stopped. The file is JSON lines: a header
+// naming the scan, then one entry per finished task. Entries are buffered
+// and flushed periodically, so a crash loses at most the last few seconds.
+type checkpoint struct {
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	enc *json.Encoder
+	err error // First write error
+
+	done     map[string]bool // Tasks finished by earlier runs, read-only once scanning
+	restored []ScanResult    // Results from earlier runs
+}
+
+// checkpointEntry is one line of the state file
+type checkpointEntry struct {
+	Scan   string      `json:"scan,omitempty"` // Header only: fingerprint of the scan settings
+	Task   string      `json:"task,omitempty"` // "proto/host:port" or "proto/name@ip:port"
+	Result *ScanResult `json:"result,omitempty"`
+}
+
+// Key identifying a task in the state file
+func (t scanTask) key() string {
+	if t.Name != "" {
+		return t.Proto + "/" + t.Name + "@" + t.Addr
+	}
+	return t.Proto + "/" + t.Addr
+}
+
+// Fingerprint of the settings that decide which tasks a scan has, so a
+// state file is never resumed into a different scan
+func (s *Scanner) fingerprint(protos []string) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%q|%q|%v|%q|%q|%v|%v|%d|%q|%v|%v|%v", s.Targets, s.TargetsFile, s.Ports, protos, s.ExcludeHosts, s.IncludeNetworkBroadcast, s.ResolveAll, s.IPVersion, s.EndpointsFile, s.AllowSpecial, s.StopOnFirstOpen, s.PortsByProto)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Open the state file at path, loading what earlier runs finished, or
+// create it for a fresh scan. A truncated last line, left by a crash
+// mid-write, is dropped.
+func openCheckpoint(path, fingerprint string) (*checkpoint, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	c := &checkpoint{f: f, done: map[string]bool{}}
+	good, err := c.load(fingerprint)
+	if err == nil {
+		err = f.Truncate(good)
+	}
+	if err == nil {
+		_, err = f.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	c.w = bufio.NewWriter(f)
+	c.enc = json.NewEncoder(c.w)
+	if good == 0 {
+		c.enc.Encode(checkpointEntry{Scan: fingerprint})
+	}
+	return c, nil
+}
+
+// Read the entries already in the file, returning the offset just past
+// the last complete one
+func (c *checkpoint) load(fingerprint string) (int64, error) {
+	r := bufio.NewReader(c.f)
+	var good int64
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return good, nil // Anything left is a partial line
+		}
+		if err != nil {
+			return 0, err
+		}
+		var e checkpointEntry
+		if jerr := json.Unmarshal(bytes.TrimSpace(line), &e); jerr != nil {
+			return good, nil
+		}
+		switch {
+		case good == 0 && e.Scan != fingerprint:
+			return 0, errors.New("state file belongs to a different scan")
+		case e.Task != "":
+			c.done[e.Task] = true
+			if e.Result != nil {
+				c.restored = append(c.restored, *e.Result)
+			}
+		}
+		good += int64(len(line))
+	}
+}
+
+// Record a finished task and its result, if it produced one
+func (c *checkpoint) record(t scanTask, r *ScanResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.enc.Encode(checkpointEntry{Task: t.key(), Result: r}); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// Write buffered entries out to the file
+func (c *checkpoint) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.w.Flush(); err != nil && c.err == nil {
+		c.err = err
+	}
+}
+
+// Flush and close the file, removing it when the scan finished cleanly.
+// Returns the first error seen while writing.
+func (c *checkpoint) close(remove bool) error {
+	c.flush()
+	if err := c.f.Close(); err != nil && c.err == nil {
+		c.err = err
+	}
+	if remove && c.err == nil {
+		c.err = os.Remove(c.f.Name())
+	}
+	return c.err
+}
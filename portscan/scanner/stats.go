@@ -0,0 +1,187 @@
+package scanner
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of a scan's counters and throughput. Durations are
+// also given in seconds so the JSON form is easy to consume.
+type Stats struct {
+	Total        int64 `json:"total"`     // Tasks planned
+	Completed    int64 `json:"completed"` // Tasks finished
+	Open         int64 `json:"open"`
+	Closed       int64 `json:"closed"`
+	Filtered     int64 `json:"filtered"`
+	OpenFiltered int64 `json:"open_filtered"`
+	HostsUp      int64 `json:"hosts_up"`         // Hosts that answered discovery
+	HostsDown    int64 `json:"hosts_down"`       // Hosts skipped as down
+	Excluded     int64 `json:"hosts_excluded"`   // Requested hosts removed by ExcludeHosts
+	Unresolved   int64 `json:"hosts_unresolved"` // Target hostnames whose lookup failed
+	Skipped      int64 `json:"tasks_skipped"`    // Tasks called off because their host already had an open port
+
+	DuplicateHosts int64 `json:"duplicate_hosts"` // Repeated or overlapping target entries dropped
+	DuplicatePorts int64 `json:"duplicate_ports"` // Repeated ports dropped
+
+	// TCP connection attempts for ports (SYN probes with Scanner.SYN),
+	// counting every retry: how many were made, how many failed for any
+	// reason including refusals, how many of those timed out, and how
+	// many attempts were retries of an earlier one
+	DialAttempts int64 `json:"dial_attempts"`
+	DialFailures int64 `json:"dial_failures"`
+	DialTimeouts int64 `json:"dial_timeouts"`
+	DialRetries  int64 `json:"dial_retries"`
+
+	Interrupted       bool  `json:"interrupted"`
+	TimedOut          bool  `json:"timed_out"`           // Interrupted because ctx's deadline passed
+	MaxResultsReached bool  `json:"max_results_reached"` // Stopped early by Scanner.MaxResults; not Interrupted
+	Seed              int64 `json:"seed,omitempty"`      // Randomize seed, to reproduce the order
+
+	Elapsed        time.Duration `json:"-"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	PortsPerSec    float64       `json:"ports_per_sec"` // Completed tasks per second
+	ETA            time.Duration `json:"-"`             // Estimated time left, 0 once done
+	ETASeconds     float64       `json:"eta_seconds"`
+}
+
+// Stats returns the counters of the current or last scan. It is safe to
+// call from any goroutine while Scan is running; the elapsed time stops
+// when Scan returns.
+func (s *Scanner) Stats() Stats {
+	st := Stats{
+		Total:        s.total.Load(),
+		Completed:    s.completed.Load(),
+		Open:         s.open.Load(),
+		Closed:       s.closed.Load(),
+		Filtered:     s.filtered.Load(),
+		OpenFiltered: s.openFiltered.Load(),
+		HostsUp:      s.hostsUp.Load(),
+		HostsDown:    s.hostsDown.Load(),
+		Excluded:     s.hostsExcluded.Load(),
+		Unresolved:   s.unresolved.Load(),
+		Skipped:      s.skipped.Load(),
+
+		DuplicateHosts: s.duplicateHosts.Load(),
+		DuplicatePorts: s.duplicatePorts.Load(),
+		DialAttempts:   s.dialAttempts.Load(),
+		DialFailures:   s.dialFailures.Load(),
+		DialTimeouts:   s.dialTimeouts.Load(),
+		DialRetries:    s.dialRetries.Load(),
+		Interrupted:    s.interrupted.Load(),
+		TimedOut:       s.timedOut.Load(),
+
+		MaxResultsReached: s.maxResultsReached.Load(),
+	}
+	if s.Randomize {
+		st.Seed = s.Seed
+	}
+	start := s.started.Load()
+	if start == 0 {
+		return st
+	}
+	end := s.finished.Load()
+	if end == 0 {
+		end = time.Now().UnixNano()
+	}
+	st.Elapsed = time.Duration(end - start)
+	st.ElapsedSeconds = st.Elapsed.Seconds()
+	if st.ElapsedSeconds > 0 {
+		st.PortsPerSec = float64(st.Completed) / st.ElapsedSeconds
+	}
+	if left := st.Total - st.Completed; left > 0 && st.PortsPerSec > 0 {
+		st.ETA = time.Duration(float64(left) / st.PortsPerSec * float64(time.Second))
+		st.ETASeconds = st.ETA.Seconds()
+	}
+	return st
+}
+
+// Bump the per-state counter for a collected result
+func (s *Scanner) countState(state string) {
+	switch state {
+	case StateOpen:
+		s.open.Add(1)
+	case StateClosed:
+		s.closed.Add(1)
+	case StateFiltered:
+		s.filtered.Add(1)
+	case StateOpenFiltered:
+		s.openFiltered.Add(1)
+	}
+}
+
+// Zero every counter and start the clock for a new scan
+func (s *Scanner) resetStats() {
+	for _, c := range []*atomic.Int64{&s.completed, &s.total, &s.hostsUp, &s.hostsDown, &s.hostsExcluded, &s.duplicateHosts, &s.duplicatePorts,
+		&s.unresolved, &s.skipped, &s.open, &s.closed, &s.filtered, &s.openFiltered, &s.finished,
+		&s.dialAttempts, &s.dialFailures, &s.dialTimeouts, &s.dialRetries} {
+		c.Store(0)
+	}
+	s.dialsMu.Lock()
+	s.hostDials = map[string]*HostDials{}
+	s.dialsMu.Unlock()
+	s.interrupted.Store(false)
+	s.timedOut.Store(false)
+	s.maxResultsReached.Store(false)
+	s.started.Store(time.Now().UnixNano())
+}
+
+// HostDials is the connection attempt accounting of one host, as in Stats
+type HostDials struct {
+	Host     string // Address dialed
+	Attempts int64
+	Failures int64
+	Timeouts int64
+	Retries  int64
+}
+
+// Account for one connection attempt to host that ended with err, nil
+// when it connected; retry says whether it repeats an earlier attempt
+func (s *Scanner) countDial(host string, retry bool, err error) {
+	s.dialAttempts.Add(1)
+	s.dialsMu.Lock()
+	defer s.dialsMu.Unlock()
+	h := s.hostDials[host]
+	if h == nil {
+		h = &HostDials{Host: host}
+		s.hostDials[host] = h
+	}
+	h.Attempts++
+	if retry {
+		s.dialRetries.Add(1)
+		h.Retries++
+	}
+	if err != nil {
+		s.dialFailures.Add(1)
+		h.Failures++
+		if timedOut(err) {
+			s.dialTimeouts.Add(1)
+			h.Timeouts++
+		}
+	}
+}
+
+// HostDialStats returns the connection attempt accounting of the hosts
+// of the last scan that had a timeout or a retry, the ones where the
+// network may have got in the way, most timeouts first. Refusals alone,
+// the usual answer of a closed port, don't count. Safe to call while
+// Scan is running.
+func (s *Scanner) HostDialStats() []HostDials {
+	s.dialsMu.Lock()
+	defer s.dialsMu.Unlock()
+	var hosts []HostDials
+	for _, h := range s.hostDials {
+		if h.Timeouts > 0 || h.Retries > 0 {
+			hosts = append(hosts, *h)
+		}
+	}
+	slices.SortFunc(hosts, func(a, b HostDials) int {
+		if c := cmp.Compare(b.Timeouts, a.Timeouts); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Host, b.Host)
+	})
+	return hosts
+}
@@ -0,0 +1,177 @@
+package scanner
+
+import (
+	"fmt"
+	"time"
+)
+
+// Thresholds used when the corresponding Scanner field is zero
+const (
+	DefaultSuspectOpenRatio     = 0.5
+	DefaultSuspectMinPorts      = 20
+	DefaultSuspectLatencyFactor = 10
+	DefaultSuspectSilentRun     = 50
+	DefaultSuspectSameBanner    = 5
+)
+
+// A latency spike must also be at least this much slower than the host's
+// fastest connect, so jitter on fast links doesn't count
+const suspectSpikeFloor = 100 * time.Millisecond
+
+// Reasons a host's results look untrustworthy, for SuspectHost.Reason
+const (
+	SuspectMostlyOpen   = "mostly-open"   // Too many ports open: likely a tarpit accepting everything
+	SuspectLatencySpike = "latency-spike" // Connects suddenly slowed down: likely rate limiting
+	SuspectWentSilent   = "went-silent"   // Answered, then only timeouts: likely blocked mid-scan
+	SuspectSharedBanner = "shared-banner" // Many ports with the same banner: likely a honeypot or proxy
+)
+
+// SuspectHost is a host whose results follow a pattern more typical of
+// tarpits, rate limits or a scan being blocked than of real services
+type SuspectHost struct {
+	Target string
+	IP     string // Address scanned when Target is a hostname
+	Reason string // One of the Suspect* constants
+	Detail string // What was seen, for people
+}
+
+// BannerCount is how many of a host's open TCP ports sent a banner and
+// how many different banners there were among them. Real services
+// rarely share one banner across many ports.
+type BannerCount struct {
+	Target   string
+	IP       string // Address scanned when Target is a hostname
+	Ports    int    // Open ports with a banner
+	Distinct int    // Different banners among them
+}
+
+// hostPattern tracks one host's TCP results as they are collected
+type hostPattern struct {
+	probed, open, answered int
+	fastest, spike         float64 // Connect latencies in ms; spike is the first one past the threshold
+	spikePort              int
+	silent                 int            // Filtered results since the last answer
+	banners                map[string]int // Open ports per banner
+	bannered               int            // Open ports with a banner
+}
+
+type hostKey struct{ target, ip string }
+
+// Record r in the patterns of its host. Called from the collector only.
+func (s *Scanner) trackPattern(r ScanResult) {
+	if r.Proto != "tcp" {
+		return
+	}
+	key := hostKey{r.Target, r.IP}
+	p := s.patterns[key]
+	if p == nil {
+		p = &hostPattern{}
+		s.patterns[key] = p
+		s.patternOrder = append(s.patternOrder, key)
+	}
+	p.probed++
+	switch r.State {
+	case StateOpen:
+		p.open++
+		p.answered++
+		p.silent = 0
+		if r.Banner != "" {
+			if p.banners == nil {
+				p.banners = map[string]int{}
+			}
+			p.banners[r.Banner]++
+			p.bannered++
+		}
+		if r.LatencyMs > 0 {
+			factor := s.SuspectLatencyFactor
+			if factor == 0 {
+				factor = DefaultSuspectLatencyFactor
+			}
+			floor := float64(suspectSpikeFloor.Microseconds()) / 1000
+			if p.fastest > 0 && p.spike == 0 && factor > 0 && r.LatencyMs >= factor*p.fastest && r.LatencyMs-p.fastest >= floor {
+				p.spike, p.spikePort = r.LatencyMs, r.Port
+			}
+			if p.fastest == 0 || r.LatencyMs < p.fastest {
+				p.fastest = r.LatencyMs
+			}
+		}
+	case StateClosed:
+		p.answered++
+		p.silent = 0
+	case StateFiltered:
+		p.silent++
+	}
+}
+
+// SuspectHosts returns the hosts of the last scan whose TCP results look
+// fake or cut short, in the order they were first seen, so their results
+// can be taken with a grain of salt:
+//
+//   - more than SuspectOpenRatio of its ports open, once at least
+//     SuspectMinPorts were probed (SuspectMostlyOpen)
+//   - an open port that took SuspectLatencyFactor times longer to connect
+//     than the host's fastest, and at least 100ms longer (SuspectLatencySpike)
+//   - SuspectSilentRun answers, open or closed, followed by at least as
+//     many timeouts and nothing else (SuspectWentSilent)
+//   - SuspectSameBanner or more open ports sending the exact same banner
+//     (SuspectSharedBanner)
+//
+// Call it once Scan has returned.
+func (s *Scanner) SuspectHosts() []SuspectHost {
+	ratio, minPorts, run, same := s.SuspectOpenRatio, s.SuspectMinPorts, s.SuspectSilentRun, s.SuspectSameBanner
+	if ratio == 0 {
+		ratio = DefaultSuspectOpenRatio
+	}
+	if minPorts == 0 {
+		minPorts = DefaultSuspectMinPorts
+	}
+	if run == 0 {
+		run = DefaultSuspectSilentRun
+	}
+	if same == 0 {
+		same = DefaultSuspectSameBanner
+	}
+	var suspects []SuspectHost
+	for _, key := range s.patternOrder {
+		p := s.patterns[key]
+		flag := func(reason, detail string) {
+			suspects = append(suspects, SuspectHost{Target: key.target, IP: key.ip, Reason: reason, Detail: detail})
+		}
+		if ratio > 0 && p.probed >= minPorts && float64(p.open) > ratio*float64(p.probed) {
+			flag(SuspectMostlyOpen, fmt.Sprintf("%d of %d ports open", p.open, p.probed))
+		}
+		if p.spike > 0 {
+			flag(SuspectLatencySpike, fmt.Sprintf("connect took %.2fms on port %d, fastest was %.2fms", p.spike, p.spikePort, p.fastest))
+		}
+		if run > 0 && p.answered >= run && p.silent >= run {
+			flag(SuspectWentSilent, fmt.Sprintf("%d ports answered, then the last %d timed out", p.answered, p.silent))
+		}
+		if shared := maxCount(p.banners); same > 0 && shared >= same {
+			flag(SuspectSharedBanner, fmt.Sprintf("%d ports sent the same banner, %d distinct among %d", shared, len(p.banners), p.bannered))
+		}
+	}
+	return suspects
+}
+
+// BannerCounts returns, for every host of the last scan with a banner on
+// at least one open TCP port, how many banners there were and how many
+// distinct ones, in the order hosts were first seen. Call it once Scan
+// has returned.
+func (s *Scanner) BannerCounts() []BannerCount {
+	var counts []BannerCount
+	for _, key := range s.patternOrder {
+		if p := s.patterns[key]; p.bannered > 0 {
+			counts = append(counts, BannerCount{Target: key.target, IP: key.ip, Ports: p.bannered, Distinct: len(p.banners)})
+		}
+	}
+	return counts
+}
+
+// The largest count in m
+func maxCount(m map[string]int) int {
+	top := 0
+	for _, n := range m {
+		top = max(top, n)
+	}
+	return top
+}
@@ -0,0 +1,245 @@
+package scanner
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// TCP header flags
+const (
+	tcpSYN = 0x02
+	tcpRST = 0x04
+	tcpACK = 0x10
+)
+
+// synScanner sends bare TCP SYNs over a raw IPv4 socket and reads the
+// answers off the same socket: a SYN-ACK means open and an RST closed.
+// The handshake is never completed; the kernel, knowing no socket for
+// the reply, answers a SYN-ACK with an RST of its own. All probes share
+// one source port, and replies are matched on the target's address and
+// port.
+type synScanner struct {
+	conn    net.PacketConn
+	srcPort uint16
+	source  net.IP // SourceIP, or nil to ask the routing table per target
+
+	mu      sync.Mutex
+	waiting map[synKey]chan string // State from the reply
+	routes  map[string]net.IP      // Local address used to reach each target
+}
+
+type synKey struct {
+	ip   string
+	port uint16
+}
+
+// SYN-scan the TCP port of result on the IPv4 address ip, retrying
+// unanswered probes the way scanOnce retries dial timeouts, within PortBudget
+func (s *Scanner) synScan(ctx context.Context, ip net.IP, result ScanResult) (ScanResult, bool) {
+	result.State, result.Reason = StateFiltered, ReasonNoResponse
+	deadline := s.portDeadline()
+	for i := 0; i < s.retries; i++ {
+		if err := s.wait(ctx); err != nil {
+			return result, false
+		}
+		state, rtt, answered, err := s.syn.probe(ctx, ip, result.Port, s.attemptTimeout(deadline))
+		if ctx.Err() != nil {
+			return result, false
+		}
+		switch {
+		case err != nil:
+			s.countDial(ip.String(), i > 0, err)
+		case !answered:
+			s.countDial(ip.String(), i > 0, os.ErrDeadlineExceeded)
+		case state == StateClosed:
+			s.countDial(ip.String(), i > 0, syscall.ECONNREFUSED)
+		default:
+			s.countDial(ip.String(), i > 0, nil)
+		}
+		if err != nil {
+			s.log.Debug("SYN probe failed", "ip", ip, "port", result.Port, "err", err)
+			result.State, result.Reason = classifyDialError(err), dialReason(err)
+			return result, true
+		}
+		if answered {
+			result.State, result.Reason = state, ReasonConnRefused
+			if state == StateOpen {
+				result.Reason, result.LatencyMs = ReasonSynAck, float64(rtt.Microseconds())/1000
+			}
+			return result, true
+		}
+		wait := s.retryWait(i)
+		if i == s.retries-1 || !s.budgetLeft(deadline, wait) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return result, false
+		case <-time.After(wait):
+		}
+	}
+	return result, true
+}
+
+// Open the raw socket. It fails without root or CAP_NET_RAW.
+func newSynScanner(source net.IP) (*synScanner, error) {
+	conn, err := net.ListenPacket("ip4:tcp", "0.0.0.0")
+	if err != nil {
+		return nil, err
+	}
+	sc := &synScanner{
+		conn:    conn,
+		srcPort: uint16(32768 + rand.Intn(28000)),
+		source:  source.To4(),
+		waiting: map[synKey]chan string{},
+		routes:  map[string]net.IP{},
+	}
+	go sc.read()
+	return sc, nil
+}
+
+// Probe port on the IPv4 address ip with one SYN, waiting up to timeout.
+// Returns the port's state and, for an answer, the round-trip time; ok is
+// false when no answer came.
+func (sc *synScanner) probe(ctx context.Context, ip net.IP, port int, timeout time.Duration) (state string, rtt time.Duration, ok bool, err error) {
+	src, err := sc.route(ip)
+	if err != nil {
+		return "", 0, false, err
+	}
+	key := synKey{ip: ip.String(), port: uint16(port)}
+	reply := make(chan string, 1)
+	sc.mu.Lock()
+	sc.waiting[key] = reply
+	sc.mu.Unlock()
+	defer func() {
+		sc.mu.Lock()
+		delete(sc.waiting, key)
+		sc.mu.Unlock()
+	}()
+
+	start := time.Now()
+	if _, err := sc.conn.WriteTo(synSegment(src, ip, sc.srcPort, uint16(port)), &net.IPAddr{IP: ip}); err != nil {
+		return "", 0, false, err
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case state := <-reply:
+		return state, time.Since(start), true, nil
+	case <-t.C:
+		return "", 0, false, nil
+	case <-ctx.Done():
+		return "", 0, false, ctx.Err()
+	}
+}
+
+// The local address packets to ip leave from, found by asking the kernel
+// to route a UDP socket there; nothing is sent
+func (sc *synScanner) route(ip net.IP) (net.IP, error) {
+	if sc.source != nil {
+		return sc.source, nil
+	}
+	sc.mu.Lock()
+	src, ok := sc.routes[ip.String()]
+	sc.mu.Unlock()
+	if ok {
+		return src, nil
+	}
+	c, err := net.Dial("udp4", net.JoinHostPort(ip.String(), "9"))
+	if err != nil {
+		return nil, err
+	}
+	src = c.LocalAddr().(*net.UDPAddr).IP.To4()
+	c.Close()
+	sc.mu.Lock()
+	sc.routes[ip.String()] = src
+	sc.mu.Unlock()
+	return src, nil
+}
+
+// Read TCP segments until the socket is closed, handing SYN-ACKs and RSTs
+// aimed at our source port to the probe waiting for them
+func (sc *synScanner) read() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := sc.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+		seg := buf[:n]
+		if len(seg) < 20 || binary.BigEndian.Uint16(seg[2:4]) != sc.srcPort {
+			continue
+		}
+		var state string
+		switch flags := seg[13]; {
+		case flags&(tcpSYN|tcpACK) == tcpSYN|tcpACK:
+			state = StateOpen
+		case flags&tcpRST != 0:
+			state = StateClosed
+		default:
+			continue
+		}
+		addr, ok := from.(*net.IPAddr)
+		if !ok {
+			continue
+		}
+		key := synKey{ip: addr.IP.String(), port: binary.BigEndian.Uint16(seg[0:2])}
+		sc.mu.Lock()
+		if reply, ok := sc.waiting[key]; ok {
+			delete(sc.waiting, key) // Only the first answer counts
+			reply <- state
+		}
+		sc.mu.Unlock()
+	}
+}
+
+func (sc *synScanner) close() {
+	sc.conn.Close()
+}
+
+// Build a TCP SYN segment from src:srcPort to dst:dstPort, with an MSS
+// option as real stacks send, so it doesn't stand out
+func synSegment(src, dst net.IP, srcPort, dstPort uint16) []byte {
+	seg := make([]byte, 24)
+	binary.BigEndian.PutUint16(seg[0:2], srcPort)
+	binary.BigEndian.PutUint16(seg[2:4], dstPort)
+	binary.BigEndian.PutUint32(seg[4:8], rand.Uint32()) // Sequence number
+	seg[12] = 6 << 4                                    // Data offset: 6 words
+	seg[13] = tcpSYN
+	binary.BigEndian.PutUint16(seg[14:16], 1024) // Window
+	seg[20], seg[21] = 2, 4                      // MSS option
+	binary.BigEndian.PutUint16(seg[22:24], 1460)
+	binary.BigEndian.PutUint16(seg[16:18], tcpChecksum(src, dst, seg))
+	return seg
+}
+
+// The TCP checksum of seg over the IPv4 pseudo header
+func tcpChecksum(src, dst net.IP, seg []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(b[i])<<8 | uint32(b[i+1])
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(src.To4())
+	add(dst.To4())
+	sum += 6 + uint32(len(seg)) // Zero, protocol and TCP length
+	add(seg)
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
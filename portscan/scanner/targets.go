@@ -0,0 +1,439 @@
+package scanner
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Largest CIDR we are willing to enumerate (host bits): an IPv4 /8 or an
+// IPv6 /104. IPv6 networks are sparse, so a /64 is far beyond walking.
+const maxCIDRHostBits = 24
+
+// targetOptions controls how target entries expand into hosts
+type targetOptions struct {
+	keepEdges bool         // Keep the network and broadcast addresses
+	exclude   []*net.IPNet // Addresses never to scan
+	family    int          // Only scan IPv4 (4) or IPv6 (6) addresses, 0 for both
+
+	allowSpecial bool // Keep special addresses inside CIDR blocks
+}
+
+// specialNet is a range of addresses that a broad scan almost never means
+// to hit, skipped inside CIDR targets unless allowed
+type specialNet struct {
+	network *net.IPNet
+	kind    string
+}
+
+var specialNets = []specialNet{
+	{mustCIDR("127.0.0.0/8"), "loopback"},
+	{mustCIDR("::1/128"), "loopback"},
+	{mustCIDR("169.254.0.0/16"), "link-local"},
+	{mustCIDR("fe80::/10"), "link-local"},
+	{mustCIDR("224.0.0.0/4"), "multicast"},
+	{mustCIDR("ff00::/8"), "multicast"},
+}
+
+func mustCIDR(s string) *net.IPNet {
+	_, n, err := net.ParseCIDR(s)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
+
+// Special ranges inside block that should be skipped. A block that lies
+// wholly within a special range was asked for explicitly, so nothing in it
+// is skipped.
+func specialsIn(block *net.IPNet) []specialNet {
+	ones, _ := block.Mask.Size()
+	var skip []specialNet
+	for _, sp := range specialNets {
+		spOnes, _ := sp.network.Mask.Size()
+		switch {
+		case sp.network.Contains(block.IP) && spOnes <= ones:
+			return nil
+		case block.Contains(sp.network.IP) && ones <= spOnes:
+			skip = append(skip, sp)
+		}
+	}
+	return skip
+}
+
+// targetSpec is one target entry: a single host, or a CIDR block whose
+// addresses are enumerated lazily while feeding tasks
+type targetSpec struct {
+	host    string       // Hostname or IP when network is nil
+	name    string       // Hostname that host was resolved from, if any
+	network *net.IPNet   // CIDR block to enumerate
+	skip    []specialNet // Special ranges inside network left out
+	opts    targetOptions
+}
+
+// Parse -exclude-hosts style entries, IPs or CIDR blocks, into networks.
+// A bare IP becomes a single-address block.
+func parseExcludes(entries []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if ip := net.ParseIP(entry); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid excluded host %q: want an IP or CIDR", entry)
+		}
+		nets = append(nets, network)
+	}
+	return nets, nil
+}
+
+// Canonical form of a host so equivalent spellings compare equal: IPs in
+// their shortest form (IPv4-mapped IPv6 as plain IPv4), names lowercased
+// without a trailing dot
+func normalizeHost(host string) string {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return addr.Unmap().String()
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Parse a single target entry, a hostname, IP or CIDR block
+func parseTarget(entry string, opts targetOptions) (targetSpec, error) {
+	if !strings.Contains(entry, "/") {
+		return targetSpec{host: normalizeHost(entry), opts: opts}, nil
+	}
+	_, network, err := net.ParseCIDR(entry)
+	if err != nil {
+		return targetSpec{}, fmt.Errorf("invalid CIDR target %q: %v", entry, err)
+	}
+	ones, bits := network.Mask.Size()
+	if bits-ones > maxCIDRHostBits {
+		if bits == 8*net.IPv6len {
+			return targetSpec{}, fmt.Errorf("IPv6 CIDR target %q is too large to enumerate (max /%d); list the addresses in use or a narrower prefix", entry, bits-maxCIDRHostBits)
+		}
+		return targetSpec{}, fmt.Errorf("CIDR target %q is too large to enumerate (max /%d)", entry, bits-maxCIDRHostBits)
+	}
+	spec := targetSpec{network: network, opts: opts}
+	if !opts.allowSpecial {
+		spec.skip = specialsIn(network)
+	}
+	return spec, nil
+}
+
+// Parse target entries into hosts and CIDR blocks, dropping entries that
+// repeat or fall inside another entry. Returns how many were dropped.
+func parseTargets(entries []string, opts targetOptions) ([]targetSpec, int, error) {
+	specs := []targetSpec{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		spec, err := parseTarget(entry, opts)
+		if err != nil {
+			return nil, 0, err
+		}
+		specs = append(specs, spec)
+	}
+	kept, dups := dedupeSpecs(specs)
+	return kept, dups, nil
+}
+
+// Drop specs whose hosts another spec already yields. A block listed
+// after addresses inside it replaces them, so only the block is kept.
+func dedupeSpecs(specs []targetSpec) ([]targetSpec, int) {
+	kept := []targetSpec{}
+	for _, spec := range specs {
+		if coveredBy(spec, kept) {
+			continue
+		}
+		kept = slices.DeleteFunc(kept, func(k targetSpec) bool { return spec.covers(k) })
+		kept = append(kept, spec)
+	}
+	return kept, len(specs) - len(kept)
+}
+
+// Report whether one of specs yields every host t does
+func coveredBy(t targetSpec, specs []targetSpec) bool {
+	for _, spec := range specs {
+		if spec.covers(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Report whether t yields every host o does. Hostnames only match the same
+// name; IP specs cover each other when o's address range sits inside t's
+// and none of it is in a special range t skips.
+func (t targetSpec) covers(o targetSpec) bool {
+	tFirst, tLast, tok := t.bounds()
+	oFirst, oLast, ook := o.bounds()
+	if !tok || !ook {
+		return !tok && !ook && t.host == o.host
+	}
+	if tFirst.Compare(oFirst) > 0 || oLast.Compare(tLast) > 0 {
+		return false
+	}
+	for _, sp := range t.skip {
+		spFirst, spLast := netBounds(sp.network)
+		if spFirst.Compare(oLast) <= 0 && oFirst.Compare(spLast) <= 0 {
+			return false // Some of o's addresses are left out of t
+		}
+	}
+	return true
+}
+
+// First and last address the spec yields, ok is false for hostnames.
+// Special ranges in skip may leave holes in between, which covers checks
+// for separately.
+func (t targetSpec) bounds() (first, last netip.Addr, ok bool) {
+	if t.network == nil {
+		addr, err := netip.ParseAddr(t.host)
+		return addr, addr, err == nil
+	}
+	first, last = netBounds(t.network)
+	if t.skipEdges() {
+		first, last = first.Next(), last.Prev()
+	}
+	return first, last, true
+}
+
+// First and last address of block
+func netBounds(block *net.IPNet) (first, last netip.Addr) {
+	first, _ = netip.AddrFromSlice(block.IP)
+	end := make(net.IP, len(block.IP))
+	for i := range end {
+		end[i] = block.IP[i] | ^block.Mask[i]
+	}
+	last, _ = netip.AddrFromSlice(end)
+	return first.Unmap(), last.Unmap()
+}
+
+// Stream the targets listed in a file, one per line, calling fn for each.
+// Blank lines and # comments are skipped. The file is read line by line so
+// huge lists never sit in memory; iteration stops early if fn returns false.
+func eachTargetInFile(path string, opts targetOptions, fn func(targetSpec) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		entry, _, _ := strings.Cut(sc.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		spec, err := parseTarget(entry, opts)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if !fn(spec) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// Stream the host:port endpoints listed in a file, one per line, calling
+// fn with each host and its ports. IPv6 hosts need brackets ([::1]:22);
+// the port may be anything ParsePorts accepts in a single token, such as
+// 8000-8100 or https. Blank lines and # comments are skipped, and
+// iteration stops early if fn returns false.
+func eachEndpointInFile(path string, opts targetOptions, fn func(t targetSpec, ports []int) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		entry, _, _ := strings.Cut(sc.Text(), "#")
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		host, port, err := net.SplitHostPort(entry)
+		if err != nil || host == "" {
+			return fmt.Errorf("%s:%d: invalid endpoint %q: want host:port", path, line, entry)
+		}
+		ports, err := parsePortToken(port)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		if !fn(targetSpec{host: normalizeHost(host), opts: opts}, ports) {
+			return nil
+		}
+	}
+	return sc.Err()
+}
+
+// Report whether the first and last addresses of the block are skipped.
+// Only IPv4 has a broadcast address, and /31 and /32 have no spare addresses.
+func (t targetSpec) skipEdges() bool {
+	ones, bits := t.network.Mask.Size()
+	return !t.opts.keepEdges && bits == 32 && ones <= 30
+}
+
+// IP version of an address, 4 or 6, or 0 if it isn't an IP
+func ipFamily(ip net.IP) int {
+	switch {
+	case ip == nil:
+		return 0
+	case ip.To4() != nil:
+		return 4
+	}
+	return 6
+}
+
+// IP version of the spec's addresses, 0 for a hostname
+func (t targetSpec) family() int {
+	if t.network != nil {
+		return ipFamily(t.network.IP)
+	}
+	return ipFamily(net.ParseIP(t.host))
+}
+
+// Report whether the spec is an IP or block of the address family that
+// isn't being scanned
+func (t targetSpec) otherFamily() bool {
+	f := t.family()
+	return t.opts.family != 0 && f != 0 && f != t.opts.family
+}
+
+// Report whether ip is in one of the excluded blocks, a skipped special
+// range or of the family not being scanned. Hostnames are checked once
+// resolved, so an unresolved name is never excluded.
+func (t targetSpec) excluded(host string) bool {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if t.opts.family != 0 && ipFamily(ip) != t.opts.family {
+		return true
+	}
+	for _, n := range t.opts.exclude {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, sp := range t.skip {
+		if sp.network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Number of addresses the spec covers before exclusions
+func (t targetSpec) size() int {
+	if t.network == nil {
+		return 1
+	}
+	ones, bits := t.network.Mask.Size()
+	n := 1 << (bits - ones)
+	if t.skipEdges() {
+		n -= 2
+	}
+	return n
+}
+
+// Number of hosts this spec expands to, and how many more were removed by
+// exclusions. Blocks that overlap an exclusion are walked to count exactly.
+func (t targetSpec) count() (hosts, excluded int) {
+	n := t.size()
+	if t.otherFamily() {
+		return 0, n
+	}
+	overlaps := len(t.skip) > 0
+	for _, e := range t.opts.exclude {
+		if t.network == nil || e.Contains(t.network.IP) || t.network.Contains(e.IP) {
+			overlaps = true
+			break
+		}
+	}
+	if !overlaps {
+		return n, 0
+	}
+	t.each(func(string) bool {
+		hosts++
+		return true
+	})
+	return hosts, n - hosts
+}
+
+// Call fn for every host in the spec that isn't excluded, without
+// materializing the whole block. Iteration stops early if fn returns false.
+func (t targetSpec) each(fn func(host string) bool) {
+	if t.otherFamily() {
+		return // Every address would be excluded
+	}
+	if t.network == nil {
+		if !t.excluded(t.host) {
+			fn(t.host)
+		}
+		return
+	}
+	n := t.size()
+	ip := make(net.IP, len(t.network.IP))
+	copy(ip, t.network.IP)
+	if t.skipEdges() {
+		incIP(ip)
+	}
+	for i := 0; i < n; i++ {
+		if host := ip.String(); !t.excluded(host) && !fn(host) {
+			return
+		}
+		incIP(ip)
+	}
+}
+
+// Up to the last n hosts of the spec that aren't excluded, in order,
+// found by walking back from the end of the block
+func (t targetSpec) lastHosts(n int) []string {
+	hosts := []string{}
+	if t.network == nil || t.otherFamily() {
+		t.each(func(host string) bool {
+			hosts = append(hosts, host)
+			return true
+		})
+		return hosts
+	}
+	first, addr, _ := t.bounds()
+	for len(hosts) < n && addr.IsValid() && first.Compare(addr) <= 0 {
+		if host := addr.String(); !t.excluded(host) {
+			hosts = append(hosts, host)
+		}
+		addr = addr.Prev()
+	}
+	slices.Reverse(hosts)
+	return hosts
+}
+
+// Increment an IP address in place
+func incIP(ip net.IP) {
+	for i := len(ip) - 1; i >= 0; i-- {
+		ip[i]++
+		if ip[i] != 0 {
+			return
+		}
+	}
+}
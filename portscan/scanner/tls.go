@@ -0,0 +1,70 @@
+package scanner
+
+import (
+	"crypto/tls"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TLSInfo holds the interesting bits of a service's TLS certificate
+type TLSInfo struct {
+	Version  string    `json:"version" xml:"version"`
+	Subject  string    `json:"subject" xml:"subject"`
+	Issuer   string    `json:"issuer" xml:"issuer"`
+	SANs     []string  `json:"sans,omitempty" xml:"san"`
+	NotAfter time.Time `json:"not_after" xml:"not_after"`
+}
+
+// Ports where we go straight to a TLS handshake instead of a plain read
+var tlsPorts = map[int]bool{
+	443: true, 465: true, 636: true, 853: true, 990: true, 993: true,
+	995: true, 5986: true, 6443: true, 8443: true, 9443: true,
+}
+
+// Report whether a banner looks like readable text. Bytes that aren't
+// UTF-8 are binary, even though ranging over them yields U+FFFD, which is
+// printable; only a character cut short at the end of the read is let go.
+func isPrintable(s string) bool {
+	for i, r := range s {
+		if r == utf8.RuneError && !strings.HasPrefix(s[i:], "\uFFFD") {
+			return !utf8.FullRuneInString(s[i:])
+		}
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// Perform a TLS handshake over conn and collect the peer certificate.
+// The whole handshake is bounded by timeout so it can't hang the worker.
+// On success the returned connection can be used to read a banner.
+func tlsHandshake(conn net.Conn, host string, timeout time.Duration) (*tls.Conn, *TLSInfo, error) {
+	cfg := &tls.Config{InsecureSkipVerify: true} // We want the cert, not to trust it
+	if net.ParseIP(host) == nil {
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		return nil, nil, err
+	}
+	tc.SetDeadline(time.Time{})
+
+	state := tc.ConnectionState()
+	info := &TLSInfo{Version: tls.VersionName(state.Version)}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		info.Subject = cert.Subject.String()
+		info.Issuer = cert.Issuer.String()
+		info.NotAfter = cert.NotAfter
+		info.SANs = append(info.SANs, cert.DNSNames...)
+		for _, ip := range cert.IPAddresses {
+			info.SANs = append(info.SANs, ip.String())
+		}
+	}
+	return tc, info, nil
+}
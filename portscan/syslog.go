@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// Syslog priority parts: open ports are logged as user-level notices
+const (
+	syslogUser   = 1
+	syslogNotice = 5
+)
+
+// Where local syslog daemons listen: Linux, macOS, the BSDs
+var syslogSockets = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogWriter ships open ports to syslog as they are found: to the local
+// daemon in the traditional BSD format, or to a remote collector over UDP
+// or TCP in RFC 5424 format. Delivery is best effort; a failure is warned
+// about once and never stops the scan. Only used from OnResult, so there
+// is no locking.
+type syslogWriter struct {
+	network, addr string // Remote collector, or "" for the local daemon
+	hostname      string
+	conn          net.Conn
+	stream        bool // conn needs framing: TCP or a unix stream socket
+	down          bool // Couldn't connect; nothing more is sent
+	warned        bool
+}
+
+// Make a writer for -syslog-addr, given as [udp://|tcp://]host[:port]
+// with UDP and port 514 by default, or empty for the local daemon.
+// Nothing is dialed yet.
+func newSyslogWriter(addr string) (*syslogWriter, error) {
+	w := &syslogWriter{hostname: "-"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		w.hostname = name
+	}
+	if addr == "" {
+		return w, nil
+	}
+	w.network = "udp"
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid -syslog-addr %q: want [udp://|tcp://]host[:port]", addr)
+		}
+		w.network, addr = u.Scheme, u.Host
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "514")
+	}
+	w.addr = addr
+	return w, nil
+}
+
+// Send r if it is an open port
+func (w *syslogWriter) result(r scanner.ScanResult) {
+	if r.State != scanner.StateOpen {
+		return
+	}
+	msg := fmt.Sprintf("target=%s port=%d proto=%s state=%s", r.Target, r.Port, r.Proto, r.State)
+	if r.IP != "" {
+		msg += " ip=" + r.IP
+	}
+	if r.Service != "" {
+		msg += " service=" + r.Service
+	}
+	if r.Banner != "" {
+		msg += " banner=" + strconv.Quote(r.Banner)
+	}
+	w.send(syslogNotice, msg)
+}
+
+// Send one message, connecting first if needed. After a failed write the
+// next message reconnects; once connecting fails, the writer gives up so
+// later results don't each wait on a dead collector.
+func (w *syslogWriter) send(severity int, msg string) {
+	if w.down {
+		return
+	}
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			w.down = true
+			w.warn(err)
+			return
+		}
+	}
+	pri := syslogUser*8 + severity
+	var line string
+	if w.network == "" {
+		line = fmt.Sprintf("<%d>%s portscan[%d]: %s", pri, time.Now().Format(time.Stamp), os.Getpid(), msg)
+	} else {
+		line = fmt.Sprintf("<%d>1 %s %s portscan %d - - %s", pri, time.Now().Format("2006-01-02T15:04:05.000000Z07:00"), w.hostname, os.Getpid(), msg)
+	}
+	switch {
+	case w.stream && w.network == "":
+		line += "\n"
+	case w.stream:
+		line = strconv.Itoa(len(line)) + " " + line // Octet counting, RFC 6587
+	}
+	w.conn.SetWriteDeadline(time.Now().Add(time.Second)) // A stuck collector mustn't stall the scan
+	if _, err := w.conn.Write([]byte(line)); err != nil {
+		w.warn(err)
+		w.conn.Close()
+		w.conn = nil
+	}
+}
+
+// Dial the collector, or the first local socket that answers
+func (w *syslogWriter) connect() error {
+	if w.network != "" {
+		conn, err := net.DialTimeout(w.network, w.addr, time.Second)
+		if err != nil {
+			return err
+		}
+		w.conn, w.stream = conn, w.network == "tcp"
+		return nil
+	}
+	for _, path := range syslogSockets {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				w.conn, w.stream = conn, network == "unix"
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no local syslog daemon found; use -syslog-addr")
+}
+
+func (w *syslogWriter) warn(err error) {
+	if !w.warned {
+		w.warned = true
+		logger.Warn("can't send results to syslog, they are only in the normal output", "addr", w.addr, "err", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package main
+
+import "os"
+
+// No portable way to ask the console its size here, so assume the classic
+func termSize(f *os.File) (width, height int) {
+	return 80, 24
+}
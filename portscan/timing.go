@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// timingTemplate presets the speed-related flags for a -timing level
+type timingTemplate struct {
+	name    string
+	workers int
+	rate    int // Attempts per second, 0 for unlimited
+	timeout time.Duration
+	retries int
+}
+
+// -timing levels, from slowest and quietest to fastest and loudest. Level
+// 3 matches the flag defaults.
+var timingTemplates = []timingTemplate{
+	{"paranoid", 1, 1, 10 * time.Second, 5},
+	{"sneaky", 5, 10, 5 * time.Second, 4},
+	{"polite", 20, 100, 5 * time.Second, 3},
+	{"normal", 100, 0, 5 * time.Second, 3},
+	{"aggressive", 500, 0, 2 * time.Second, 2},
+	{"insane", 1000, 0, 750 * time.Millisecond, 1},
+}
+
+// -fast scans this many of the most common ports with fastTiming
+const fastPorts = 100
+
+// Timing for -fast: a short list of mostly-open ports answers quickly, so
+// a tight timeout and a single retry cost little accuracy
+var fastTiming = timingTemplate{"fast", 500, 0, time.Second, 1}
+
+// Apply the -timing template to every knob not set explicitly on the
+// command line
+func applyTiming(level int) error {
+	if level < 0 || level >= len(timingTemplates) {
+		return fmt.Errorf("invalid -timing %d: must be 0 to %d", level, len(timingTemplates)-1)
+	}
+	logger.Info("timing template", "level", level, "name", timingTemplates[level].name)
+	applyTemplate(timingTemplates[level])
+	return nil
+}
+
+// Set up -fast: the fastPorts most common ports and fastTiming, unless
+// -timing picks the speed. Other port selections can't be combined with it.
+func applyFast() error {
+	for _, name := range []string{"ports", "top-ports", "start-port", "end-port"} {
+		if flagSet(name) {
+			return fmt.Errorf("-fast can't be combined with -%s", name)
+		}
+	}
+	topPorts = fastPorts
+	if !flagSet("timing") {
+		applyTemplate(fastTiming)
+	}
+	return nil
+}
+
+// Copy t's settings into every knob not set explicitly on the command line
+func applyTemplate(t timingTemplate) {
+	if !flagSet("workers") {
+		workerCount = t.workers
+	}
+	if !flagSet("rate") {
+		maxRate = t.rate
+	}
+	if !flagSet("timeout") && !flagSet("connect-timeout") {
+		timeout = t.timeout
+	}
+	if !flagSet("retries") {
+		retries = t.retries
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// Escape sequences for the full-screen view: the alternate screen keeps the
+// user's scrollback intact, and the cursor is hidden while redrawing
+const (
+	ansiAltScreen  = "\033[?1049h\033[?25l"
+	ansiMainScreen = "\033[?25h\033[?1049l"
+	ansiHome       = "\033[H"
+	ansiClearLine  = "\033[K"
+	ansiClearBelow = "\033[J"
+)
+
+// Lines above the list of open ports: title, progress, counts, blank
+const tuiHeader = 4
+
+// Live full-screen view of a scan: progress, rate and a scrolling list of
+// open ports. Results arrive through add, from the scanner's OnResult.
+type tui struct {
+	s       *scanner.Scanner
+	targets string
+	color   bool
+
+	mu   sync.Mutex
+	open []string // Rendered lines for open ports, oldest first
+}
+
+// Record an open port for the list; other states only show in the counts
+func (t *tui) add(r scanner.ScanResult) {
+	if r.State != scanner.StateOpen {
+		return
+	}
+	line := fmt.Sprintf("%s:%d/%s", r.Target, r.Port, r.Proto)
+	if r.IP != "" {
+		line += " (" + r.IP + ")"
+	}
+	if r.Service != "" {
+		line += "  " + r.Service
+	}
+	if b := strings.TrimSpace(r.Banner); b != "" {
+		line += fmt.Sprintf("  %q", b)
+	}
+	t.mu.Lock()
+	t.open = append(t.open, line)
+	t.mu.Unlock()
+}
+
+// Take over the terminal and redraw until stop is closed, then restore the
+// normal screen so the results and summary print as usual. Log output is
+// held back meanwhile and replayed afterwards. done is signalled on return.
+func (t *tui) run(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	logSink.hold()
+	defer logSink.release()
+	fmt.Print(ansiAltScreen)
+	defer fmt.Print(ansiMainScreen)
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		t.draw()
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Repaint the whole screen in place, fitting the list to the terminal
+func (t *tui) draw() {
+	width, height := termSize(os.Stdout)
+	st := t.s.Stats()
+	var b strings.Builder
+	b.WriteString(ansiHome)
+	line := func(s string) {
+		if len(s) > width {
+			s = s[:width]
+		}
+		b.WriteString(s + ansiClearLine + "\r\n")
+	}
+	line(fmt.Sprintf("portscan %s  scanning %s  (Ctrl+C to stop)", version, t.targets))
+	line(strings.TrimSuffix(progressLine(st), ansiClearLine))
+	line(fmt.Sprintf("open %d  closed %d  filtered %d  open|filtered %d", st.Open, st.Closed, st.Filtered, st.OpenFiltered))
+	line("")
+
+	t.mu.Lock()
+	rows := max(height-tuiHeader-1, 1)
+	shown := t.open[max(len(t.open)-rows, 0):]
+	for _, s := range shown {
+		if len(s) > width {
+			s = s[:width]
+		}
+		if t.color {
+			s = colorState(scanner.StateOpen, s)
+		}
+		b.WriteString(s + ansiClearLine + "\r\n")
+	}
+	if len(t.open) == 0 {
+		line("No open ports yet")
+	}
+	t.mu.Unlock()
+	b.WriteString(ansiClearBelow)
+	fmt.Print(b.String())
+}
+
+// Writer behind the logger. While the TUI owns the screen, log records are
+// buffered instead of scribbling over it, then written out on release.
+type heldWriter struct {
+	mu   sync.Mutex
+	held bool
+	buf  []byte
+}
+
+var logSink = &heldWriter{}
+
+func (w *heldWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.held {
+		w.buf = append(w.buf, p...)
+		return len(p), nil
+	}
+	return os.Stderr.Write(p)
+}
+
+func (w *heldWriter) hold() {
+	w.mu.Lock()
+	w.held = true
+	w.mu.Unlock()
+}
+
+func (w *heldWriter) release() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.held = false
+	os.Stderr.Write(w.buf)
+	w.buf = nil
+}
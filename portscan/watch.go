@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+// Identity of a port across -watch runs
+type watchKey struct {
+	target, ip, proto string
+	port              int
+}
+
+func keyOf(r scanner.ScanResult) watchKey {
+	return watchKey{r.Target, r.IP, r.Proto, r.Port}
+}
+
+// A port whose state differs between two -watch runs. From is empty for a
+// port that wasn't in the previous run, To for one missing from this run.
+type portChange struct {
+	Time   time.Time          `json:"time"`
+	Change string             `json:"change"` // "opened", "closed" or "changed"; "baseline" for the first run
+	From   string             `json:"from,omitempty"`
+	To     string             `json:"to,omitempty"`
+	Result scanner.ScanResult `json:"result"`
+}
+
+// Compare two runs, returning the ports whose state changed where either
+// side would be shown, in the order of cur and then of prev
+func diffRuns(prev, cur []scanner.ScanResult, at time.Time) []portChange {
+	before := make(map[watchKey]scanner.ScanResult, len(prev))
+	for _, r := range prev {
+		before[keyOf(r)] = r
+	}
+	var changes []portChange
+	seen := make(map[watchKey]bool, len(cur))
+	for _, r := range cur {
+		k := keyOf(r)
+		seen[k] = true
+		old, ok := before[k]
+		if ok && old.State == r.State || !visible(r) && (!ok || !visible(old)) {
+			continue
+		}
+		c := portChange{Time: at, Change: "changed", From: old.State, To: r.State, Result: r}
+		switch {
+		case r.State == scanner.StateOpen:
+			c.Change = "opened"
+		case old.State == scanner.StateOpen:
+			c.Change = "closed"
+		}
+		changes = append(changes, c)
+	}
+	for _, r := range prev {
+		if !seen[keyOf(r)] && visible(r) {
+			// Its host no longer resolves or was excluded: gone either way
+			changes = append(changes, portChange{Time: at, Change: "closed", From: r.State, Result: r})
+		}
+	}
+	return changes
+}
+
+// Write changes as text lines, or one JSON object per line with -json
+func writeChanges(w io.Writer, changes []portChange, asJSON, color bool) {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		for _, c := range changes {
+			enc.Encode(c)
+		}
+		return
+	}
+	for _, c := range changes {
+		r := c.Result
+		mark, word := "[-]", c.Change
+		if c.Change == "opened" {
+			mark = "[+]"
+		}
+		if color {
+			state := c.To
+			if state == "" {
+				state = scanner.StateClosed
+			}
+			mark, word = colorState(state, mark), colorState(state, word)
+		}
+		line := fmt.Sprintf("%s %s %s %s", c.Time.Format(time.RFC3339), mark, net.JoinHostPort(r.Target, strconv.Itoa(r.Port)), word)
+		if r.IP != "" {
+			line += " (" + r.IP + ")"
+		}
+		if r.Proto == "udp" {
+			line += " (udp)"
+		}
+		switch {
+		case c.From == "":
+			line += " (new)"
+		case c.To == "":
+			line += fmt.Sprintf(" (was %s, no longer scanned)", c.From)
+		default:
+			line += fmt.Sprintf(" (was %s, now %s)", c.From, c.To)
+		}
+		if c.Change == "opened" && r.Banner != "" {
+			line += " - " + bannerText(r)
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// Scan every interval until ctx is cancelled, printing the first run in
+// full and after that only the ports that changed. Every state is kept in
+// between so a port going from open to closed is told apart from one that
+// stopped being scanned. Returns the exit code for the last complete run.
+func watch(ctx context.Context, s *scanner.Scanner, every time.Duration, color bool) int {
+	s.Filter = nil // Diffing needs every state, not just the visible ones
+	var prev []scanner.ScanResult
+	code := exitOK
+	for first := true; ; first = false {
+		started := time.Now()
+		results, err := s.Scan(ctx)
+		switch {
+		case ctx.Err() != nil:
+			return code // Interrupted: a partial run would look like closures
+		case err != nil && first:
+			fatal(err)
+		case err != nil:
+			logger.Error("watch scan failed, keeping the previous results", "err", err)
+		default:
+			sortResults(results, sortBy)
+			stats := s.Stats()
+			code = exitCode(stats)
+			logger.Info("watch scan complete", "open", stats.Open, "took", time.Since(started).Round(time.Millisecond))
+			if first {
+				shown := make([]scanner.ScanResult, 0, len(results))
+				for _, r := range results {
+					if visible(r) {
+						shown = append(shown, r)
+					}
+				}
+				if jsonOutput {
+					baseline := diffRuns(nil, shown, started)
+					for i := range baseline {
+						baseline[i].Change = "baseline"
+					}
+					writeChanges(os.Stdout, baseline, true, false)
+				} else {
+					writeText(os.Stdout, shown, textOptions{color: color, reasons: verbose, latency: showLatency, tree: treeOutput})
+					fmt.Printf("\nWatching every %s; only changes are printed from now on\n", every)
+				}
+			} else {
+				writeChanges(os.Stdout, diffRuns(prev, results, started), jsonOutput, color)
+			}
+			prev = results
+		}
+
+		// Runs start every interval; one that overran starts the next at once
+		select {
+		case <-ctx.Done():
+			return code
+		case <-time.After(time.Until(started.Add(every))):
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/l-lesley-y30/Port-Scan/portscan/scanner"
+)
+
+func TestDiffRuns(t *testing.T) {
+	result := func(target string, port int, state string) scanner.ScanResult {
+		return scanner.ScanResult{Target: target, Port: port, Proto: "tcp", State: state}
+	}
+	prev := []scanner.ScanResult{
+		result("a", 22, scanner.StateOpen),
+		result("a", 25, scanner.StateClosed),
+		result("a", 80, scanner.StateClosed),
+		result("a", 443, scanner.StateOpen),
+		result("b", 22, scanner.StateOpen),
+	}
+	cur := []scanner.ScanResult{
+		result("a", 22, scanner.StateOpen),
+		result("a", 25, scanner.StateFiltered),
+		result("a", 80, scanner.StateOpen),
+		result("a", 443, scanner.StateFiltered),
+		result("a", 8080, scanner.StateClosed),
+	}
+	tests := []struct {
+		name         string
+		showFiltered bool
+		want         []string
+	}{
+		{"open ports only", false, []string{"a:80 opened closed>open", "a:443 closed open>filtered", "b:22 closed open>"}},
+		{"filtered shown", true, []string{"a:25 changed closed>filtered", "a:80 opened closed>open", "a:443 closed open>filtered", "b:22 closed open>"}},
+	}
+	defer func(saved bool) { showFilter = saved }(showFilter)
+	at := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		showFilter = tt.showFiltered
+		var got []string
+		for _, c := range diffRuns(prev, cur, at) {
+			if !c.Time.Equal(at) {
+				t.Errorf("%s: change at %s, want %s", tt.name, c.Time, at)
+			}
+			got = append(got, fmt.Sprintf("%s:%d %s %s>%s", c.Result.Target, c.Result.Port, c.Change, c.From, c.To))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if changes := diffRuns(cur, cur, at); len(changes) != 0 {
+		t.Errorf("identical runs: got %d changes, want none", len(changes))
+	}
+}